@@ -0,0 +1,64 @@
+package broker
+
+import (
+	"errors"
+	"sync"
+)
+
+var ErrClosed = errors.New("broker closed")
+
+// Message is an event received from a broker. Ack must be called once it has been handled.
+type Message struct {
+	Channel string
+	Payload string
+	// ID is set by transports that log events, such as a stream entry ID, and
+	// is the same on every node. Empty otherwise.
+	ID  string
+	ack func()
+}
+
+func NewMessage(channel string, payload string, ack func()) *Message {
+	return &Message{Channel: channel, Payload: payload, ack: ack}
+}
+
+func (message *Message) Ack() {
+	if message.ack != nil {
+		message.ack()
+	}
+}
+
+// Subscription delivers messages on C until Unsubscribe is called or the broker closes.
+type Subscription struct {
+	C           <-chan *Message
+	unsubscribe func()
+	once        sync.Once
+}
+
+func NewSubscription(messages <-chan *Message, unsubscribe func()) *Subscription {
+	return &Subscription{C: messages, unsubscribe: unsubscribe}
+}
+
+func (subscription *Subscription) Unsubscribe() {
+	subscription.once.Do(subscription.unsubscribe)
+}
+
+// Broker carries events between nodes.
+type Broker interface {
+	Name() string
+	// Ready reports whether the broker is currently connected.
+	Ready() bool
+	Publish(channel string, payload []byte) error
+	Subscribe(channel string) (*Subscription, error)
+	Close() error
+}
+
+// Default is the broker selected at startup.
+var Default Broker
+
+func Publish(channel string, payload []byte) error {
+	return Default.Publish(channel, payload)
+}
+
+func Subscribe(channel string) (*Subscription, error) {
+	return Default.Subscribe(channel)
+}
@@ -0,0 +1,102 @@
+package broker
+
+import "sync"
+
+// fanout dispatches payloads to the local subscribers of each channel.
+type fanout struct {
+	mutex       sync.RWMutex
+	subscribers map[string]map[*subscriber]bool
+	closed      bool
+}
+
+type subscriber struct {
+	messages chan *Message
+	done     chan struct{}
+	once     sync.Once
+}
+
+func newFanout() *fanout {
+	return &fanout{subscribers: make(map[string]map[*subscriber]bool)}
+}
+
+func (f *fanout) subscribe(channel string) (*Subscription, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.closed {
+		return nil, ErrClosed
+	}
+
+	sub := &subscriber{
+		messages: make(chan *Message, 64),
+		done:     make(chan struct{}),
+	}
+	if _, ok := f.subscribers[channel]; !ok {
+		f.subscribers[channel] = make(map[*subscriber]bool)
+	}
+	f.subscribers[channel][sub] = true
+
+	return NewSubscription(sub.messages, func() { f.remove(channel, sub) }), nil
+}
+
+// remove signals done first so a deliver blocked on this subscriber lets go of the read lock.
+func (f *fanout) remove(channel string, sub *subscriber) {
+	sub.once.Do(func() { close(sub.done) })
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if subscribers, ok := f.subscribers[channel]; ok && subscribers[sub] {
+		delete(subscribers, sub)
+		if len(subscribers) == 0 {
+			delete(f.subscribers, channel)
+		}
+		close(sub.messages)
+	}
+}
+
+func (f *fanout) deliver(channel string, payload string) {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+
+	for sub := range f.subscribers[channel] {
+		select {
+		case sub.messages <- NewMessage(channel, payload, nil):
+		case <-sub.done:
+		}
+	}
+}
+
+func (f *fanout) channels() []string {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+
+	channels := make([]string, 0, len(f.subscribers))
+	for channel := range f.subscribers {
+		channels = append(channels, channel)
+	}
+	return channels
+}
+
+func (f *fanout) close() {
+	// Release any deliver blocked on a subscriber before taking the write lock
+	f.mutex.RLock()
+	for _, channelSubscribers := range f.subscribers {
+		for sub := range channelSubscribers {
+			sub.once.Do(func() { close(sub.done) })
+		}
+	}
+	f.mutex.RUnlock()
+
+	f.mutex.Lock()
+	subscribers := f.subscribers
+	f.subscribers = make(map[string]map[*subscriber]bool)
+	f.closed = true
+	f.mutex.Unlock()
+
+	for _, channelSubscribers := range subscribers {
+		for sub := range channelSubscribers {
+			close(sub.messages)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package broker
+
+// memoryBroker delivers within a single process, for tests and single-node development.
+type memoryBroker struct {
+	fanout *fanout
+}
+
+func NewMemory() Broker {
+	return &memoryBroker{fanout: newFanout()}
+}
+
+func (broker *memoryBroker) Name() string {
+	return "memory"
+}
+
+func (broker *memoryBroker) Ready() bool {
+	return true
+}
+
+func (broker *memoryBroker) Publish(channel string, payload []byte) error {
+	broker.fanout.mutex.RLock()
+	closed := broker.fanout.closed
+	broker.fanout.mutex.RUnlock()
+	if closed {
+		return ErrClosed
+	}
+
+	broker.fanout.deliver(channel, string(payload))
+	return nil
+}
+
+func (broker *memoryBroker) Subscribe(channel string) (*Subscription, error) {
+	return broker.fanout.subscribe(channel)
+}
+
+func (broker *memoryBroker) Close() error {
+	broker.fanout.close()
+	return nil
+}
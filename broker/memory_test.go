@@ -0,0 +1,136 @@
+package broker
+
+import (
+	"testing"
+	"time"
+)
+
+// receive waits briefly for the next message, returning false if none arrives.
+func receive(subscription *Subscription) (*Message, bool) {
+	select {
+	case message, ok := <-subscription.C:
+		return message, ok
+	case <-time.After(100 * time.Millisecond):
+		return nil, false
+	}
+}
+
+func TestMemoryFanout(t *testing.T) {
+	tests := []struct {
+		name     string
+		channels []string
+		publish  string
+		want     []bool
+	}{
+		{name: "single subscriber", channels: []string{"broadcast"}, publish: "broadcast", want: []bool{true}},
+		{name: "every subscriber of the channel", channels: []string{"broadcast", "broadcast"}, publish: "broadcast", want: []bool{true, true}},
+		{name: "other channels are untouched", channels: []string{"broadcast", "typing_events"}, publish: "typing_events", want: []bool{false, true}},
+		{name: "no subscribers", channels: nil, publish: "broadcast", want: nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			broker := NewMemory()
+			defer broker.Close()
+
+			subscriptions := make([]*Subscription, len(test.channels))
+			for i, channel := range test.channels {
+				subscription, err := broker.Subscribe(channel)
+				if err != nil {
+					t.Fatalf("Subscribe(%q): %v", channel, err)
+				}
+				subscriptions[i] = subscription
+			}
+
+			if err := broker.Publish(test.publish, []byte("payload")); err != nil {
+				t.Fatalf("Publish: %v", err)
+			}
+
+			for i, subscription := range subscriptions {
+				message, ok := receive(subscription)
+				if ok != test.want[i] {
+					t.Fatalf("subscriber %d received = %v, want %v", i, ok, test.want[i])
+				}
+				if ok && (message.Channel != test.publish || message.Payload != "payload") {
+					t.Errorf("subscriber %d got %s/%q", i, message.Channel, message.Payload)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryUnsubscribe(t *testing.T) {
+	broker := NewMemory()
+	defer broker.Close()
+
+	kept, _ := broker.Subscribe("broadcast")
+	dropped, _ := broker.Subscribe("broadcast")
+	dropped.Unsubscribe()
+	dropped.Unsubscribe()
+
+	if _, ok := <-dropped.C; ok {
+		t.Fatal("unsubscribed channel still open")
+	}
+
+	if err := broker.Publish("broadcast", []byte("payload")); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	if _, ok := receive(kept); !ok {
+		t.Error("remaining subscriber missed the message")
+	}
+}
+
+func TestMemoryClose(t *testing.T) {
+	broker := NewMemory()
+	subscription, _ := broker.Subscribe("broadcast")
+
+	if err := broker.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	if _, ok := <-subscription.C; ok {
+		t.Error("subscription still open after Close")
+	}
+	if err := broker.Publish("broadcast", []byte("payload")); err != ErrClosed {
+		t.Errorf("Publish after Close = %v, want ErrClosed", err)
+	}
+	if _, err := broker.Subscribe("broadcast"); err != ErrClosed {
+		t.Errorf("Subscribe after Close = %v, want ErrClosed", err)
+	}
+	subscription.Unsubscribe()
+}
+
+func TestFanoutUnsubscribeReleasesBlockedDeliver(t *testing.T) {
+	fanout := newFanout()
+	subscription, _ := fanout.subscribe("broadcast")
+
+	// Fill the subscriber's buffer so the next deliver blocks
+	for i := 0; i < cap(fanout.subscriberList("broadcast")[0].messages); i++ {
+		fanout.deliver("broadcast", "payload")
+	}
+
+	delivered := make(chan struct{})
+	go func() {
+		fanout.deliver("broadcast", "blocked")
+		close(delivered)
+	}()
+
+	subscription.Unsubscribe()
+	select {
+	case <-delivered:
+	case <-time.After(time.Second):
+		t.Fatal("deliver still blocked after Unsubscribe")
+	}
+}
+
+// subscriberList returns the channel's subscribers, for tests.
+func (f *fanout) subscriberList(channel string) []*subscriber {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+
+	subscribers := make([]*subscriber, 0, len(f.subscribers[channel]))
+	for sub := range f.subscribers[channel] {
+		subscribers = append(subscribers, sub)
+	}
+	return subscribers
+}
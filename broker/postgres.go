@@ -0,0 +1,261 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	postgresRetryDelay    = time.Second
+	postgresMaxRetryDelay = 30 * time.Second
+
+	// pg_notify refuses payloads of 8000 bytes or more. Bigger events are
+	// stored in broker_payloads and the notification carries a reference.
+	postgresMaxNotifyPayload = 7900
+	postgresPayloadRef       = "ref:"
+	// Stored payloads only have to outlive the listeners fetching them
+	postgresPayloadRetention = 10 * time.Minute
+)
+
+const createPayloadTable = `CREATE TABLE IF NOT EXISTS broker_payloads (
+	id BIGSERIAL PRIMARY KEY,
+	payload TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+)`
+
+// postgresBroker uses LISTEN/NOTIFY so small deployments can run without Redis.
+// Publishing goes through a pool; listening holds one dedicated connection.
+type postgresBroker struct {
+	dsn    string
+	pool   *pgxpool.Pool
+	fanout *fanout
+	ctx    context.Context
+	cancel context.CancelFunc
+	ready  atomic.Bool
+
+	// wake interrupts WaitForNotification so the listener can LISTEN to new channels
+	mutex sync.Mutex
+	dirty bool
+	wake  context.CancelFunc
+}
+
+func NewPostgres(dsn string) (Broker, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	pool, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	broker := &postgresBroker{
+		dsn:    dsn,
+		pool:   pool,
+		fanout: newFanout(),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	if _, err := pool.Exec(ctx, createPayloadTable); err != nil {
+		log.Printf("postgres broker could not create broker_payloads, events over %d bytes will fail: %v", postgresMaxNotifyPayload, err)
+	}
+	go broker.listen()
+	go broker.prunePayloads()
+
+	return broker, nil
+}
+
+func (broker *postgresBroker) Name() string {
+	return "postgres"
+}
+
+func (broker *postgresBroker) Ready() bool {
+	return broker.ready.Load()
+}
+
+func (broker *postgresBroker) Publish(channel string, payload []byte) error {
+	notification := string(payload)
+	if len(payload) > postgresMaxNotifyPayload {
+		var id int64
+		if err := broker.pool.QueryRow(broker.ctx,
+			"INSERT INTO broker_payloads (payload) VALUES ($1) RETURNING id", notification,
+		).Scan(&id); err != nil {
+			return fmt.Errorf("store %d byte payload: %w", len(payload), err)
+		}
+		notification = postgresPayloadRef + strconv.FormatInt(id, 10)
+	}
+
+	_, err := broker.pool.Exec(broker.ctx, "SELECT pg_notify($1, $2)", channel, notification)
+	return err
+}
+
+// resolvePayload fetches the event a reference notification points to.
+func (broker *postgresBroker) resolvePayload(notification string) (string, error) {
+	ref, ok := strings.CutPrefix(notification, postgresPayloadRef)
+	if !ok {
+		return notification, nil
+	}
+	id, err := strconv.ParseInt(ref, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("malformed payload reference %q", notification)
+	}
+
+	var payload string
+	err = broker.pool.QueryRow(broker.ctx, "SELECT payload FROM broker_payloads WHERE id = $1", id).Scan(&payload)
+	return payload, err
+}
+
+// prunePayloads deletes stored payloads every listener has had time to fetch.
+func (broker *postgresBroker) prunePayloads() {
+	ticker := time.NewTicker(postgresPayloadRetention / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-broker.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if _, err := broker.pool.Exec(broker.ctx,
+			"DELETE FROM broker_payloads WHERE created_at < now() - make_interval(secs => $1)",
+			postgresPayloadRetention.Seconds(),
+		); err != nil && broker.ctx.Err() == nil {
+			log.Printf("postgres broker failed to prune stored payloads: %v", err)
+		}
+	}
+}
+
+func (broker *postgresBroker) Subscribe(channel string) (*Subscription, error) {
+	subscription, err := broker.fanout.subscribe(channel)
+	if err != nil {
+		return nil, err
+	}
+	broker.resync()
+
+	return NewSubscription(subscription.C, func() {
+		subscription.Unsubscribe()
+		broker.resync()
+	}), nil
+}
+
+func (broker *postgresBroker) Close() error {
+	broker.cancel()
+	broker.fanout.close()
+	broker.pool.Close()
+	return nil
+}
+
+// resync asks the listener to bring its LISTEN set in line with the subscribers.
+func (broker *postgresBroker) resync() {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+
+	broker.dirty = true
+	if broker.wake != nil {
+		broker.wake()
+	}
+}
+
+func (broker *postgresBroker) listen() {
+	delay := postgresRetryDelay
+
+	for broker.ctx.Err() == nil {
+		conn, err := pgx.Connect(broker.ctx, broker.dsn)
+		if err != nil {
+			log.Printf("postgres broker failed to connect: %v", err)
+			time.Sleep(delay)
+			delay = min(delay*2, postgresMaxRetryDelay)
+			continue
+		}
+		delay = postgresRetryDelay
+
+		broker.ready.Store(true)
+		if err := broker.serve(conn); err != nil && broker.ctx.Err() == nil {
+			log.Printf("postgres broker listener stopped: %v", err)
+		}
+		broker.ready.Store(false)
+		conn.Close(context.Background())
+	}
+}
+
+func (broker *postgresBroker) serve(conn *pgx.Conn) error {
+	listening := make(map[string]bool)
+
+	for {
+		broker.mutex.Lock()
+		broker.dirty = false
+		broker.mutex.Unlock()
+
+		if err := broker.syncListens(conn, listening); err != nil {
+			return err
+		}
+
+		waitCtx, cancel := context.WithCancel(broker.ctx)
+		broker.mutex.Lock()
+		if broker.dirty {
+			broker.mutex.Unlock()
+			cancel()
+			continue
+		}
+		broker.wake = cancel
+		broker.mutex.Unlock()
+
+		notification, err := conn.WaitForNotification(waitCtx)
+
+		broker.mutex.Lock()
+		broker.wake = nil
+		broker.mutex.Unlock()
+		cancel()
+
+		if err != nil {
+			if broker.ctx.Err() != nil {
+				return nil
+			}
+			// Woken up to pick up a subscription change
+			if waitCtx.Err() != nil {
+				continue
+			}
+			return err
+		}
+
+		payload, err := broker.resolvePayload(notification.Payload)
+		if err != nil {
+			log.Printf("postgres broker dropped an event on %s: %v", notification.Channel, err)
+			continue
+		}
+		broker.fanout.deliver(notification.Channel, payload)
+	}
+}
+
+func (broker *postgresBroker) syncListens(conn *pgx.Conn, listening map[string]bool) error {
+	wanted := make(map[string]bool)
+	for _, channel := range broker.fanout.channels() {
+		wanted[channel] = true
+		if !listening[channel] {
+			if _, err := conn.Exec(broker.ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+				return err
+			}
+			listening[channel] = true
+		}
+	}
+
+	for channel := range listening {
+		if !wanted[channel] {
+			if _, err := conn.Exec(broker.ctx, "UNLISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+				return err
+			}
+			delete(listening, channel)
+		}
+	}
+
+	return nil
+}
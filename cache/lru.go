@@ -0,0 +1,92 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// LRU is a size-bounded cache whose entries also expire after a fixed TTL.
+type LRU[K comparable, V any] struct {
+	mutex    sync.Mutex
+	capacity int
+	ttl      time.Duration
+	items    map[K]*list.Element
+	order    *list.List
+}
+
+type lruEntry[K comparable, V any] struct {
+	key     K
+	value   V
+	expires time.Time
+}
+
+func NewLRU[K comparable, V any](capacity int, ttl time.Duration) *LRU[K, V] {
+	return &LRU[K, V]{
+		capacity: capacity,
+		ttl:      ttl,
+		items:    make(map[K]*list.Element),
+		order:    list.New(),
+	}
+}
+
+func (cache *LRU[K, V]) Get(key K) (V, bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	var zero V
+	element, ok := cache.items[key]
+	if !ok {
+		return zero, false
+	}
+
+	entry := element.Value.(*lruEntry[K, V])
+	if time.Now().After(entry.expires) {
+		cache.order.Remove(element)
+		delete(cache.items, key)
+		return zero, false
+	}
+
+	cache.order.MoveToFront(element)
+	return entry.value, true
+}
+
+func (cache *LRU[K, V]) Set(key K, value V) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	expires := time.Now().Add(cache.ttl)
+	if element, ok := cache.items[key]; ok {
+		entry := element.Value.(*lruEntry[K, V])
+		entry.value = value
+		entry.expires = expires
+		cache.order.MoveToFront(element)
+		return
+	}
+
+	cache.items[key] = cache.order.PushFront(&lruEntry[K, V]{key: key, value: value, expires: expires})
+
+	if cache.order.Len() > cache.capacity {
+		oldest := cache.order.Back()
+		cache.order.Remove(oldest)
+		delete(cache.items, oldest.Value.(*lruEntry[K, V]).key)
+	}
+}
+
+func (cache *LRU[K, V]) Delete(key K) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if element, ok := cache.items[key]; ok {
+		cache.order.Remove(element)
+		delete(cache.items, key)
+	}
+}
+
+func (cache *LRU[K, V]) Purge() {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	cache.items = make(map[K]*list.Element)
+	cache.order.Init()
+}
@@ -0,0 +1,119 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLRU(t *testing.T) {
+	tests := []struct {
+		name    string
+		ttl     time.Duration
+		actions func(cache *LRU[string, int])
+		want    map[string]int
+		missing []string
+	}{
+		{
+			name:    "get after set",
+			ttl:     time.Minute,
+			actions: func(cache *LRU[string, int]) { cache.Set("a", 1) },
+			want:    map[string]int{"a": 1},
+		},
+		{
+			name: "set replaces value",
+			ttl:  time.Minute,
+			actions: func(cache *LRU[string, int]) {
+				cache.Set("a", 1)
+				cache.Set("a", 2)
+			},
+			want: map[string]int{"a": 2},
+		},
+		{
+			name: "evicts least recently set",
+			ttl:  time.Minute,
+			actions: func(cache *LRU[string, int]) {
+				cache.Set("a", 1)
+				cache.Set("b", 2)
+				cache.Set("c", 3)
+			},
+			want:    map[string]int{"b": 2, "c": 3},
+			missing: []string{"a"},
+		},
+		{
+			name: "get refreshes recency",
+			ttl:  time.Minute,
+			actions: func(cache *LRU[string, int]) {
+				cache.Set("a", 1)
+				cache.Set("b", 2)
+				cache.Get("a")
+				cache.Set("c", 3)
+			},
+			want:    map[string]int{"a": 1, "c": 3},
+			missing: []string{"b"},
+		},
+		{
+			name: "expired entries are misses",
+			ttl:  time.Millisecond,
+			actions: func(cache *LRU[string, int]) {
+				cache.Set("a", 1)
+				time.Sleep(5 * time.Millisecond)
+			},
+			missing: []string{"a"},
+		},
+		{
+			name: "delete",
+			ttl:  time.Minute,
+			actions: func(cache *LRU[string, int]) {
+				cache.Set("a", 1)
+				cache.Set("b", 2)
+				cache.Delete("a")
+				cache.Delete("missing")
+			},
+			want:    map[string]int{"b": 2},
+			missing: []string{"a"},
+		},
+		{
+			name: "purge",
+			ttl:  time.Minute,
+			actions: func(cache *LRU[string, int]) {
+				cache.Set("a", 1)
+				cache.Set("b", 2)
+				cache.Purge()
+				cache.Set("c", 3)
+			},
+			want:    map[string]int{"c": 3},
+			missing: []string{"a", "b"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cache := NewLRU[string, int](2, test.ttl)
+			test.actions(cache)
+
+			for key, want := range test.want {
+				if got, ok := cache.Get(key); !ok || got != want {
+					t.Errorf("Get(%q) = %d, %v; want %d, true", key, got, ok, want)
+				}
+			}
+			for _, key := range test.missing {
+				if got, ok := cache.Get(key); ok {
+					t.Errorf("Get(%q) = %d, true; want a miss", key, got)
+				}
+			}
+		})
+	}
+}
+
+func TestLRUExpiredEntryFreesSlot(t *testing.T) {
+	cache := NewLRU[string, int](1, time.Millisecond)
+	cache.Set("a", 1)
+	time.Sleep(5 * time.Millisecond)
+
+	if _, ok := cache.Get("a"); ok {
+		t.Fatal("expired entry still returned")
+	}
+	if cache.order.Len() != 0 || len(cache.items) != 0 {
+		t.Errorf("expired entry still held: %d in order, %d in items", cache.order.Len(), len(cache.items))
+	}
+}
@@ -0,0 +1,126 @@
+package cache
+
+import (
+	"huddle-ws-server/database"
+	"huddle-ws-server/models"
+	"log"
+
+	"github.com/google/uuid"
+)
+
+// channelVisible is when a team member sees a channel: it is not archived and
+// their role is privileged, listed in AllowedRoles, or the list is empty.
+const channelVisible = `NOT team_channels.is_archived
+	AND team_members.deleted_at IS NULL
+	AND (team_members.role IN ('owner', 'admin')
+		OR COALESCE(cardinality(team_channels.allowed_roles), 0) = 0
+		OR team_members.role = ANY(team_channels.allowed_roles))`
+
+// ChannelIDs returns the team channels the user can see.
+func ChannelIDs(userID uuid.UUID) ([]uuid.UUID, error) {
+	if channelIDs, ok := channelSets.Get(userID); ok {
+		return channelIDs, nil
+	}
+
+	var channelIDs []uuid.UUID
+	if err := database.DB.Model(&models.TeamChannel{}).
+		Joins("JOIN team_members ON team_members.team_id = team_channels.team_id").
+		Where("team_members.user_id = ?", userID).
+		Where(channelVisible).
+		Pluck("team_channels.id", &channelIDs).Error; err != nil {
+		return nil, err
+	}
+
+	channelSets.Set(userID, channelIDs)
+	return channelIDs, nil
+}
+
+// ConversationIDs returns the direct conversations the user takes part in.
+func ConversationIDs(userID uuid.UUID) ([]uuid.UUID, error) {
+	if conversationIDs, ok := conversationSet.Get(userID); ok {
+		return conversationIDs, nil
+	}
+
+	var conversationIDs []uuid.UUID
+	if err := database.DB.Model(&models.Conversation{}).
+		Where("user1_id = ? OR user2_id = ?", userID, userID).
+		Pluck("id", &conversationIDs).Error; err != nil {
+		return nil, err
+	}
+
+	conversationSet.Set(userID, conversationIDs)
+	return conversationIDs, nil
+}
+
+// TeamIDs returns the teams the user belongs to.
+func TeamIDs(userID uuid.UUID) ([]uuid.UUID, error) {
+	if teamIDs, ok := teamSets.Get(userID); ok {
+		return teamIDs, nil
+	}
+
+	var teamIDs []uuid.UUID
+	if err := database.DB.Model(&models.TeamMember{}).
+		Where("user_id = ?", userID).
+		Pluck("team_id", &teamIDs).Error; err != nil {
+		return nil, err
+	}
+
+	teamSets.Set(userID, teamIDs)
+	return teamIDs, nil
+}
+
+// VisibleChannels reports which channels each user can see, bypassing the
+// cache. teamID and channelIDs narrow the check when set.
+func VisibleChannels(userIDs []uuid.UUID, teamID *uuid.UUID, channelIDs []uuid.UUID) (map[uuid.UUID]map[uuid.UUID]bool, error) {
+	query := database.DB.Model(&models.TeamChannel{}).
+		Select("team_members.user_id, team_channels.id AS channel_id").
+		Joins("JOIN team_members ON team_members.team_id = team_channels.team_id").
+		Where("team_members.user_id IN ?", userIDs).
+		Where(channelVisible)
+	if teamID != nil {
+		query = query.Where("team_channels.team_id = ?", *teamID)
+	}
+	if channelIDs != nil {
+		query = query.Where("team_channels.id IN ?", channelIDs)
+	}
+
+	var rows []struct {
+		UserID    uuid.UUID
+		ChannelID uuid.UUID
+	}
+	if err := query.Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	visible := make(map[uuid.UUID]map[uuid.UUID]bool)
+	for _, row := range rows {
+		if visible[row.UserID] == nil {
+			visible[row.UserID] = make(map[uuid.UUID]bool)
+		}
+		visible[row.UserID][row.ChannelID] = true
+	}
+	return visible, nil
+}
+
+// InvalidateTeamChannels drops the cached channel sets of every member of the
+// team, so users not connected here do not resubscribe to a channel they lost.
+func InvalidateTeamChannels(teamID uuid.UUID) {
+	var userIDs []uuid.UUID
+	if err := database.DB.Model(&models.TeamMember{}).
+		Where("team_id = ?", teamID).
+		Pluck("user_id", &userIDs).Error; err != nil {
+		log.Printf("failed to load members of team %s, dropping all channel sets: %v", teamID, err)
+		channelSets.Purge()
+		return
+	}
+
+	for _, userID := range userIDs {
+		channelSets.Delete(userID)
+	}
+}
+
+func InvalidateMemberships(userID uuid.UUID) {
+	channelSets.Delete(userID)
+	conversationSet.Delete(userID)
+	teamSets.Delete(userID)
+}
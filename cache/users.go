@@ -0,0 +1,107 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"huddle-ws-server/database"
+	"huddle-ws-server/models"
+	"huddle-ws-server/rd"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+var ctx = context.Background()
+
+var (
+	users           = NewLRU[uuid.UUID, models.User](10000, 5*time.Minute)
+	channelSets     = NewLRU[uuid.UUID, []uuid.UUID](10000, 5*time.Minute)
+	conversationSet = NewLRU[uuid.UUID, []uuid.UUID](10000, 5*time.Minute)
+	teamSets        = NewLRU[uuid.UUID, []uuid.UUID](10000, 5*time.Minute)
+
+	// Share profiles between nodes through Redis as a second level
+	redisBacked bool
+	ttl         = 5 * time.Minute
+)
+
+// Init reads USER_CACHE_SIZE, USER_CACHE_TTL and USER_CACHE_REDIS.
+func Init() {
+	size := 10000
+	if value, err := strconv.Atoi(os.Getenv("USER_CACHE_SIZE")); err == nil && value > 0 {
+		size = value
+	}
+	if value, err := time.ParseDuration(os.Getenv("USER_CACHE_TTL")); err == nil && value > 0 {
+		ttl = value
+	}
+	redisBacked = os.Getenv("USER_CACHE_REDIS") == "true" && rd.Available()
+
+	users = NewLRU[uuid.UUID, models.User](size, ttl)
+	channelSets = NewLRU[uuid.UUID, []uuid.UUID](size, ttl)
+	conversationSet = NewLRU[uuid.UUID, []uuid.UUID](size, ttl)
+	teamSets = NewLRU[uuid.UUID, []uuid.UUID](size, ttl)
+
+	// user_updated events sent during an outage never arrive, so start over
+	if rd.Available() {
+		rd.OnReconnect(func(outage time.Duration) {
+			Purge()
+		})
+	}
+}
+
+func userKey(userID uuid.UUID) string {
+	return "cache:user:" + userID.String()
+}
+
+// GetUser returns the user's profile from memory, then Redis, then Postgres.
+func GetUser(userID uuid.UUID) (models.User, error) {
+	if user, ok := users.Get(userID); ok {
+		return user, nil
+	}
+
+	if redisBacked {
+		if value, err := rd.RedisClient.Get(ctx, userKey(userID)).Bytes(); err == nil {
+			var user models.User
+			if err := json.Unmarshal(value, &user); err == nil {
+				users.Set(userID, user)
+				return user, nil
+			}
+		} else if err != redis.Nil {
+			log.Printf("failed to read cached user %s: %v", userID, err)
+		}
+	}
+
+	var user models.User
+	if err := database.DB.Where("id = ?", userID).First(&user).Error; err != nil {
+		return user, err
+	}
+
+	users.Set(userID, user)
+	if redisBacked {
+		if value, err := json.Marshal(user); err == nil {
+			rd.RedisClient.Set(ctx, userKey(userID), value, ttl)
+		}
+	}
+
+	return user, nil
+}
+
+func Purge() {
+	users.Purge()
+	channelSets.Purge()
+	conversationSet.Purge()
+	teamSets.Purge()
+}
+
+// InvalidateUser drops everything cached about the user after a user_updated event.
+func InvalidateUser(userID uuid.UUID) {
+	users.Delete(userID)
+	InvalidateMemberships(userID)
+
+	if redisBacked {
+		rd.RedisClient.Del(ctx, userKey(userID))
+	}
+}
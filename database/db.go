@@ -11,13 +11,14 @@ import (
 
 var DB *gorm.DB
 
-func ConnectDatabase() {
+// DSN builds the Postgres connection string from the DB_* environment variables.
+func DSN() string {
 	ssl := "disable"
 	if os.Getenv("ENV") == "production" {
 		ssl = "require"
 	}
 
-	dsn := fmt.Sprintf(
+	return fmt.Sprintf(
 		"host=%s user=%s password=%s dbname=%s port=%s sslmode=%s",
 		os.Getenv("DB_HOST"),
 		os.Getenv("DB_USER"),
@@ -26,8 +27,10 @@ func ConnectDatabase() {
 		os.Getenv("DB_PORT"),
 		ssl,
 	)
+}
 
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+func ConnectDatabase() {
+	db, err := gorm.Open(postgres.Open(DSN()), &gorm.Config{})
 
 	if err != nil {
 		log.Fatal("Failed to connect to database")
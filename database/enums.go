@@ -0,0 +1,26 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+)
+
+// EnsureEnumValues adds any of values missing from the Postgres enum type.
+// Existing values are left alone, so it is safe to run on every start.
+func EnsureEnumValues(typeName string, values ...string) error {
+	for _, value := range values {
+		statement := fmt.Sprintf("ALTER TYPE %s ADD VALUE IF NOT EXISTS %s", quoteIdentifier(typeName), quoteLiteral(value))
+		if err := DB.Exec(statement).Error; err != nil {
+			return fmt.Errorf("add %q to enum %s: %w", value, typeName, err)
+		}
+	}
+	return nil
+}
+
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func quoteLiteral(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
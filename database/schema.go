@@ -0,0 +1,32 @@
+package database
+
+import "fmt"
+
+// readCursorsSchema creates models.ReadCursor's table. The websocket server
+// is the only writer of read cursors, so it owns the table.
+var readCursorsSchema = []string{
+	`CREATE TABLE IF NOT EXISTS read_cursors (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		created_at TIMESTAMPTZ,
+		updated_at TIMESTAMPTZ,
+		deleted_at TIMESTAMPTZ,
+		user_id UUID NOT NULL,
+		conversation_id UUID NOT NULL,
+		last_read_message_id UUID DEFAULT NULL,
+		last_read_at TIMESTAMPTZ
+	)`,
+	// mark_read upserts on this index
+	`CREATE UNIQUE INDEX IF NOT EXISTS idx_read_cursors_user_conversation ON read_cursors (user_id, conversation_id)`,
+	`CREATE INDEX IF NOT EXISTS idx_read_cursors_deleted_at ON read_cursors (deleted_at)`,
+}
+
+// EnsureReadCursors creates the read_cursors table and its indexes if they
+// are missing. It is safe to run on every start.
+func EnsureReadCursors() error {
+	for _, statement := range readCursorsSchema {
+		if err := DB.Exec(statement).Error; err != nil {
+			return fmt.Errorf("create read_cursors: %w", err)
+		}
+	}
+	return nil
+}
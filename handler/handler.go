@@ -2,57 +2,158 @@ package handler
 
 import (
 	"encoding/json"
-	"huddle-ws-server/rd"
+	"huddle-ws-server/broker"
+	"huddle-ws-server/cache"
 	"huddle-ws-server/types"
 	"huddle-ws-server/ws"
+	"log"
 
-	"github.com/go-redis/redis/v8"
 	"github.com/google/uuid"
 )
 
+// StartRedisListener subscribes to the event channels on whichever broker is configured.
 func StartRedisListener() {
-	onlineStatusCh := rd.Subscribe("user_online_status")
-	events := rd.Subscribe("broadcast")
+	listen("user_online_status", handleOnlineStatus)
+	listenMessages("broadcast", handleMessage)
+	listen("membership_events", handleMembershipEvent)
+	listen("channel_events", handleChannelEvent)
+	listen("typing_events", handleTypingEvent)
+	listen("user_updated", handleUserUpdated)
+	listen("read_events", handleReadEvent)
+	listen("user_events", handleUserEvent)
+}
 
-	go processChannel(onlineStatusCh, handleOnlineStatus)
-	go processChannel(events, handleMessage)
+func listen(channel string, handler func(msg interface{})) {
+	listenMessages(channel, func(msg *broker.Message) {
+		handler(msg.Payload)
+	})
 }
 
-func processChannel(subscription <-chan *redis.Message, handler func(msg interface{})) {
-	for msg := range subscription {
+// listenMessages is listen for handlers that need more than the payload.
+func listenMessages(channel string, handler func(msg *broker.Message)) {
+	subscription, err := broker.Subscribe(channel)
+	if err != nil {
+		log.Fatalf("Failed to subscribe to %s: %v", channel, err)
+	}
 
-		err := json.Unmarshal([]byte(msg.Payload), &msg)
-		if err != nil {
-			continue
-		}
+	go processChannel(subscription, handler)
+}
 
-		handler(msg.Payload)
+func processChannel(subscription *broker.Subscription, handler func(msg *broker.Message)) {
+	for msg := range subscription.C {
+		handler(msg)
+		msg.Ack()
 	}
 }
 
-func handleMessage(payload interface{}) {
+func handleMessage(msg *broker.Message) {
 	var broadcastPayload types.Message
-	if err := json.Unmarshal([]byte(payload.(string)), &broadcastPayload); err != nil {
+	if err := json.Unmarshal([]byte(msg.Payload), &broadcastPayload); err != nil {
 		return
 	}
 
+	ws.StampSequence(&broadcastPayload, []byte(msg.Payload), msg.ID)
+	if broadcastPayload.Type == "reaction" {
+		ws.WsManager.BroadcastReaction(broadcastPayload)
+		return
+	}
 	ws.WsManager.BroadcastMessage(broadcastPayload)
+
+	if broadcastPayload.Type == "new_message" || broadcastPayload.Type == "message_deleted" {
+		ws.QueueUnreadCounts(broadcastPayload.ChannelID, broadcastPayload.ConversationID)
+	}
 }
 
-func handleOnlineStatus(payload interface{}) {
-	var userOnlineStatus struct {
-		UserId string `json:"userId"`
-		Status string `json:"status"`
+func handleReadEvent(payload interface{}) {
+	var readEvent types.Message
+	if err := json.Unmarshal([]byte(payload.(string)), &readEvent); err != nil {
+		return
+	}
+
+	var receipt struct {
+		Data types.ReadReceiptPayload `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(payload.(string)), &receipt); err != nil {
+		return
+	}
+
+	ws.WsManager.ApplyReadReceipt(readEvent, receipt.Data)
+}
+
+func handleTypingEvent(payload interface{}) {
+	var typingEvent types.Message
+	if err := json.Unmarshal([]byte(payload.(string)), &typingEvent); err != nil {
+		return
+	}
+
+	ws.WsManager.BroadcastMessage(typingEvent)
+}
+
+func handleMembershipEvent(payload interface{}) {
+	var membershipEvent types.MembershipEvent
+	if err := json.Unmarshal([]byte(payload.(string)), &membershipEvent); err != nil {
+		return
+	}
+
+	if membershipEvent.UserId == uuid.Nil {
+		return
 	}
 
-	payloadBytes, _ := json.Marshal(payload)
-	if err := json.Unmarshal(payloadBytes, &userOnlineStatus); err != nil {
+	cache.InvalidateMemberships(membershipEvent.UserId)
+	ws.InvalidateAudience(membershipEvent.UserId, membershipEvent.TeamId != nil, membershipEvent.ConversationIds)
+	ws.WsManager.ApplyMembershipEvent(membershipEvent)
+}
+
+func handleChannelEvent(payload interface{}) {
+	var channelEvent types.ChannelEvent
+	if err := json.Unmarshal([]byte(payload.(string)), &channelEvent); err != nil {
+		return
+	}
+
+	if channelEvent.ChannelId == uuid.Nil || channelEvent.TeamId == uuid.Nil {
+		return
+	}
+
+	cache.InvalidateTeamChannels(channelEvent.TeamId)
+	ws.WsManager.ApplyChannelEvent(channelEvent)
+}
+
+func handleUserUpdated(payload interface{}) {
+	var userUpdated types.UserUpdatedEvent
+	if err := json.Unmarshal([]byte(payload.(string)), &userUpdated); err != nil {
+		return
+	}
+
+	if userUpdated.UserId == uuid.Nil {
+		return
+	}
+
+	cache.InvalidateUser(userUpdated.UserId)
+}
+
+func handleUserEvent(payload interface{}) {
+	var userEvent types.UserEvent
+	if err := json.Unmarshal([]byte(payload.(string)), &userEvent); err != nil {
+		return
+	}
+
+	if userEvent.UserId == uuid.Nil || userEvent.Event.Type == "" {
+		return
+	}
+
+	ws.WsManager.DeliverUserEvent(userEvent)
+}
+
+func handleOnlineStatus(payload interface{}) {
+	var userOnlineStatus types.UserStatusPayload
+	if err := json.Unmarshal([]byte(payload.(string)), &userOnlineStatus); err != nil {
 		return
 	}
 
-	if userOnlineStatus.UserId == "" || userOnlineStatus.Status == "" {
+	userID, err := uuid.Parse(userOnlineStatus.UserId)
+	if err != nil || userOnlineStatus.Status == "" {
 		return
 	}
 
-	ws.WsManager.BroadcastUserStatus(uuid.MustParse(userOnlineStatus.UserId), userOnlineStatus.Status)
+	ws.WsManager.BroadcastUserStatus(userID, userOnlineStatus)
 }
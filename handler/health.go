@@ -0,0 +1,25 @@
+package handler
+
+import (
+	"huddle-ws-server/broker"
+	"huddle-ws-server/ws"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// HealthCheck reports 503 while the broker is disconnected so load balancers stop routing here.
+func HealthCheck(c *fiber.Ctx) error {
+	if !broker.Default.Ready() {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"status": "unavailable",
+			"broker": broker.Default.Name(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"status":           "ok",
+		"broker":           broker.Default.Name(),
+		"droppedFrames":    ws.WsManager.DroppedFrames(),
+		"rejectedMessages": ws.WsManager.RejectedMessages(),
+	})
+}
@@ -2,13 +2,17 @@ package main
 
 import (
 	"fmt"
+	"huddle-ws-server/broker"
+	"huddle-ws-server/cache"
 	"huddle-ws-server/database"
 	"huddle-ws-server/handler"
 	"huddle-ws-server/middleware"
+	"huddle-ws-server/presence"
 	"huddle-ws-server/rd"
 	"huddle-ws-server/ws"
 	"log"
 	"os"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/websocket/v2"
@@ -30,9 +34,22 @@ func main() {
 	}
 	database.ConnectDatabase()
 
+	// Rich presence writes away, dnd and invisible into users.status
+	if err := database.EnsureEnumValues("user_status",
+		presence.StatusOnline, presence.StatusOffline, presence.StatusAway, presence.StatusDND, presence.StatusInvisible,
+	); err != nil {
+		log.Printf("Failed to migrate user_status values, status updates may fail: %v", err)
+	}
+	if err := database.EnsureReadCursors(); err != nil {
+		log.Printf("Failed to create read_cursors, mark_read and unread counts will fail: %v", err)
+	}
+
+	ws.WsManager = ws.NewManager(ws.ConfigFromEnv())
 	go ws.WsManager.Start()
 
-	rd.InitRedis()
+	initBroker()
+	cache.Init()
+	presence.Default.Start(ws.PublishUserStatus)
 
 	handler.StartRedisListener()
 
@@ -44,9 +61,43 @@ func main() {
 		return fiber.ErrUpgradeRequired
 	})
 
+	app.Get("/health", handler.HealthCheck)
+
 	app.Get("/ws", middleware.WsAuthRequired(), websocket.New(ws.WebsocketHandler))
 
 	fmt.Println("Starting server on port", port)
 
 	app.Listen(":" + port)
 }
+
+// initBroker selects the event broker from BROKER: "redis" (default), "postgres" or "memory".
+func initBroker() {
+	switch os.Getenv("BROKER") {
+	case "memory":
+		broker.Default = broker.NewMemory()
+	case "postgres":
+		postgresBroker, err := broker.NewPostgres(database.DSN())
+		if err != nil {
+			log.Fatalf("Failed to start Postgres broker: %v", err)
+		}
+		broker.Default = postgresBroker
+	default:
+		rd.InitRedis()
+		redisBroker, err := rd.NewBroker()
+		if err != nil {
+			log.Fatalf("Failed to start Redis broker: %v", err)
+		}
+		broker.Default = redisBroker
+		presence.Default = presence.NewRedis()
+
+		// Events published while Redis was down are gone, so ask clients to refetch
+		if window, err := time.ParseDuration(os.Getenv("REDIS_RESYNC_AFTER")); err == nil && window > 0 {
+			rd.OnReconnect(func(outage time.Duration) {
+				if outage >= window {
+					ws.WsManager.RequestResync()
+				}
+			})
+		}
+	}
+	log.Printf("Using %s broker", broker.Default.Name())
+}
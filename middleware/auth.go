@@ -1,8 +1,7 @@
 package middleware
 
 import (
-	"huddle-ws-server/database"
-	"huddle-ws-server/models"
+	"huddle-ws-server/cache"
 	"os"
 	"strings"
 
@@ -39,9 +38,10 @@ func WsAuthRequired() fiber.Handler {
 			})
 		}
 
-		// Get user from database
-		var user models.User
-		if err := database.DB.First(&user, "id = ?", userID).Error; err != nil {
+		// Get user from the profile cache, falling back to the database. Deleting
+		// or banning a user publishes user_updated, which evicts them everywhere
+		user, err := cache.GetUser(userID)
+		if err != nil {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 				"message": "User not found",
 			})
@@ -54,6 +54,42 @@ type TeamChannel struct {
 	Conversation   *Conversation `json:"-" gorm:"constraint:OnDelete:CASCADE;"`
 }
 
+type TeamMember struct {
+	Base
+	TeamID uuid.UUID `json:"teamId" gorm:"index;not null"`
+	UserID uuid.UUID `json:"userId" gorm:"index;not null"`
+	Role   string    `json:"role" gorm:"default:'member'"` // owner, admin, member
+}
+
+type Message struct {
+	Base
+	ConversationID   uuid.UUID  `json:"conversationId" gorm:"index;not null"`
+	SenderID         uuid.UUID  `json:"senderId" gorm:"index;not null"`
+	Content          string     `json:"content" gorm:"type:text"`
+	ContentType      string     `json:"contentType" gorm:"default:'text'"`
+	ReplyToMessageID *uuid.UUID `json:"replyToMessageId" gorm:"index;default:null"`
+	FilePath         string     `json:"filePath"`
+	IsEdited         bool       `json:"isEdited" gorm:"default:false"`
+}
+
+// MessageReaction allows each user one reaction per emoji on a message.
+type MessageReaction struct {
+	Base
+	MessageID uuid.UUID `json:"messageId" gorm:"uniqueIndex:idx_message_reactions_unique;not null"`
+	UserID    uuid.UUID `json:"userId" gorm:"uniqueIndex:idx_message_reactions_unique;not null"`
+	Emoji     string    `json:"emoji" gorm:"uniqueIndex:idx_message_reactions_unique;not null"`
+}
+
+// ReadCursor is how far a user has read a conversation. Team channels use
+// their backing conversation.
+type ReadCursor struct {
+	Base
+	UserID            uuid.UUID  `json:"userId" gorm:"uniqueIndex:idx_read_cursors_user_conversation;not null"`
+	ConversationID    uuid.UUID  `json:"conversationId" gorm:"uniqueIndex:idx_read_cursors_user_conversation;not null"`
+	LastReadMessageID *uuid.UUID `json:"lastReadMessageId" gorm:"default:null"`
+	LastReadAt        time.Time  `json:"lastReadAt"`
+}
+
 type User struct {
 	Base
 	Email           string    `json:"email" gorm:"uniqueIndex;not null"`
@@ -0,0 +1,137 @@
+package presence
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Registry tracks which users have at least one open connection anywhere in
+// the cluster. Connect and Disconnect report whether the call changed the
+// user's aggregate online state.
+type Registry interface {
+	Connect(userID uuid.UUID, connectionID uuid.UUID) (cameOnline bool, err error)
+	Disconnect(userID uuid.UUID, connectionID uuid.UUID) (wentOffline bool, err error)
+	IsOnline(userID uuid.UUID) (bool, error)
+	// GetStatus falls back to StatusOnline when the user never set one.
+	GetStatus(userID uuid.UUID) (Status, error)
+	SetStatus(userID uuid.UUID, status Status) error
+	// SwapStatus sets next only if the status is still previous, so when
+	// several nodes compute the same transition exactly one of them wins.
+	SwapStatus(userID uuid.UUID, previous Status, next Status) (swapped bool, err error)
+	// Touch records socket activity; LastActivity is the latest across the cluster.
+	Touch(userID uuid.UUID, at time.Time) error
+	LastActivity(userID uuid.UUID) (time.Time, error)
+	// Start runs background maintenance. onTransition reports changes found
+	// outside Connect and Disconnect, such as a dead node's users going offline.
+	Start(onTransition func(userID uuid.UUID, online bool))
+}
+
+// Default is the registry selected at startup.
+var Default Registry = NewLocal()
+
+// localRegistry only sees this node, for deployments without Redis.
+type localRegistry struct {
+	mutex       sync.Mutex
+	connections map[uuid.UUID]map[uuid.UUID]bool
+	statuses    map[uuid.UUID]Status
+	activity    map[uuid.UUID]time.Time
+}
+
+func NewLocal() Registry {
+	return &localRegistry{
+		connections: make(map[uuid.UUID]map[uuid.UUID]bool),
+		statuses:    make(map[uuid.UUID]Status),
+		activity:    make(map[uuid.UUID]time.Time),
+	}
+}
+
+func (registry *localRegistry) Connect(userID uuid.UUID, connectionID uuid.UUID) (bool, error) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	connections, ok := registry.connections[userID]
+	if !ok {
+		connections = make(map[uuid.UUID]bool)
+		registry.connections[userID] = connections
+	}
+	connections[connectionID] = true
+
+	return len(connections) == 1, nil
+}
+
+func (registry *localRegistry) Disconnect(userID uuid.UUID, connectionID uuid.UUID) (bool, error) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	connections, ok := registry.connections[userID]
+	if !ok || !connections[connectionID] {
+		return false, nil
+	}
+
+	delete(connections, connectionID)
+	if len(connections) > 0 {
+		return false, nil
+	}
+
+	delete(registry.connections, userID)
+	return true, nil
+}
+
+func (registry *localRegistry) IsOnline(userID uuid.UUID) (bool, error) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	return len(registry.connections[userID]) > 0, nil
+}
+
+func (registry *localRegistry) GetStatus(userID uuid.UUID) (Status, error) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	if status, ok := registry.statuses[userID]; ok {
+		return status, nil
+	}
+	return Status{Status: StatusOnline}, nil
+}
+
+func (registry *localRegistry) SetStatus(userID uuid.UUID, status Status) error {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	registry.statuses[userID] = status
+	return nil
+}
+
+func (registry *localRegistry) SwapStatus(userID uuid.UUID, previous Status, next Status) (bool, error) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	current, ok := registry.statuses[userID]
+	if !ok {
+		current = Status{Status: StatusOnline}
+	}
+	if !current.Equal(previous) {
+		return false, nil
+	}
+	registry.statuses[userID] = next
+	return true, nil
+}
+
+func (registry *localRegistry) Touch(userID uuid.UUID, at time.Time) error {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	if at.After(registry.activity[userID]) {
+		registry.activity[userID] = at
+	}
+	return nil
+}
+
+func (registry *localRegistry) LastActivity(userID uuid.UUID) (time.Time, error) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	return registry.activity[userID], nil
+}
+
+func (registry *localRegistry) Start(onTransition func(userID uuid.UUID, online bool)) {}
@@ -0,0 +1,333 @@
+package presence
+
+import (
+	"context"
+	"encoding/json"
+	"huddle-ws-server/rd"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+const (
+	heartbeatInterval = 10 * time.Second
+	heartbeatTTL      = 30 * time.Second
+	reapInterval      = 15 * time.Second
+	activityTTL       = 24 * time.Hour
+
+	nodesKey = "presence:nodes"
+)
+
+var ctx = context.Background()
+
+func userKey(userID string) string {
+	return "presence:user:" + userID
+}
+
+func statusKey(userID string) string {
+	return "presence:status:" + userID
+}
+
+func activityKey(userID string) string {
+	return "presence:activity:" + userID
+}
+
+func nodeKey(nodeID string) string {
+	return "presence:node:" + nodeID
+}
+
+func nodeConnectionsKey(nodeID string) string {
+	return "presence:node:" + nodeID + ":conns"
+}
+
+// connectScript returns the user's connection count after adding this one.
+var connectScript = redis.NewScript(`
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+redis.call('SADD', KEYS[2], ARGV[3])
+return redis.call('HLEN', KEYS[1])
+`)
+
+// disconnectScript returns -1 when the connection was already gone,
+// otherwise the user's remaining connection count.
+var disconnectScript = redis.NewScript(`
+redis.call('SREM', KEYS[2], ARGV[2])
+if redis.call('HDEL', KEYS[1], ARGV[1]) == 0 then
+	return -1
+end
+return redis.call('HLEN', KEYS[1])
+`)
+
+// reapScript removes every connection a node registered and returns the
+// users left with no connections at all.
+var reapScript = redis.NewScript(`
+local offline = {}
+for _, member in ipairs(redis.call('SMEMBERS', KEYS[1])) do
+	local separator = string.find(member, ':', 1, true)
+	local userId = string.sub(member, 1, separator - 1)
+	local connectionId = string.sub(member, separator + 1)
+	local userKey = 'presence:user:' .. userId
+	if redis.call('HDEL', userKey, connectionId) == 1 and redis.call('HLEN', userKey) == 0 then
+		table.insert(offline, userId)
+	end
+end
+redis.call('DEL', KEYS[1])
+redis.call('SREM', KEYS[2], ARGV[1])
+return offline
+`)
+
+// redisRegistry keeps a per-user hash of connection ID to node ID. Each node
+// refreshes a heartbeat key; nodes whose heartbeat expires are reaped by the
+// survivors.
+type redisRegistry struct {
+	nodeID       string
+	onTransition func(userID uuid.UUID, online bool)
+
+	// This node's own connections, re-registered if the cluster reaped us during an outage
+	mutex       sync.Mutex
+	connections map[uuid.UUID]uuid.UUID
+}
+
+func NewRedis() Registry {
+	return &redisRegistry{
+		nodeID:      rd.NodeID(),
+		connections: make(map[uuid.UUID]uuid.UUID),
+	}
+}
+
+func member(userID uuid.UUID, connectionID uuid.UUID) string {
+	return userID.String() + ":" + connectionID.String()
+}
+
+func (registry *redisRegistry) Connect(userID uuid.UUID, connectionID uuid.UUID) (bool, error) {
+	registry.mutex.Lock()
+	registry.connections[connectionID] = userID
+	registry.mutex.Unlock()
+
+	return registry.register(userID, connectionID)
+}
+
+func (registry *redisRegistry) register(userID uuid.UUID, connectionID uuid.UUID) (bool, error) {
+	count, err := connectScript.Run(ctx, rd.RedisClient,
+		[]string{userKey(userID.String()), nodeConnectionsKey(registry.nodeID)},
+		connectionID.String(), registry.nodeID, member(userID, connectionID),
+	).Int64()
+	if err != nil {
+		return false, err
+	}
+	return count == 1, nil
+}
+
+func (registry *redisRegistry) Disconnect(userID uuid.UUID, connectionID uuid.UUID) (bool, error) {
+	registry.mutex.Lock()
+	delete(registry.connections, connectionID)
+	registry.mutex.Unlock()
+
+	remaining, err := disconnectScript.Run(ctx, rd.RedisClient,
+		[]string{userKey(userID.String()), nodeConnectionsKey(registry.nodeID)},
+		connectionID.String(), member(userID, connectionID),
+	).Int64()
+	if err != nil {
+		return false, err
+	}
+	return remaining == 0, nil
+}
+
+func (registry *redisRegistry) IsOnline(userID uuid.UUID) (bool, error) {
+	count, err := rd.RedisClient.HLen(ctx, userKey(userID.String())).Result()
+	return count > 0, err
+}
+
+func (registry *redisRegistry) GetStatus(userID uuid.UUID) (Status, error) {
+	status := Status{Status: StatusOnline}
+
+	value, err := rd.RedisClient.Get(ctx, statusKey(userID.String())).Bytes()
+	if err == redis.Nil {
+		return status, nil
+	}
+	if err != nil {
+		return status, err
+	}
+
+	err = json.Unmarshal(value, &status)
+	return status, err
+}
+
+func (registry *redisRegistry) SetStatus(userID uuid.UUID, status Status) error {
+	value, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	return rd.RedisClient.Set(ctx, statusKey(userID.String()), value, 0).Err()
+}
+
+// swapStatusScript replaces the status only if it still encodes to ARGV[1].
+// ARGV[3] is 1 when the expected status is the default a missing key stands for.
+var swapStatusScript = redis.NewScript(`
+local current = redis.call('GET', KEYS[1])
+if current then
+	if current ~= ARGV[1] then
+		return 0
+	end
+elseif ARGV[3] ~= '1' then
+	return 0
+end
+redis.call('SET', KEYS[1], ARGV[2])
+return 1
+`)
+
+func (registry *redisRegistry) SwapStatus(userID uuid.UUID, previous Status, next Status) (bool, error) {
+	expected, err := json.Marshal(previous)
+	if err != nil {
+		return false, err
+	}
+	value, err := json.Marshal(next)
+	if err != nil {
+		return false, err
+	}
+	missingMatches := "0"
+	if previous.Equal(Status{Status: StatusOnline}) {
+		missingMatches = "1"
+	}
+
+	swapped, err := swapStatusScript.Run(ctx, rd.RedisClient,
+		[]string{statusKey(userID.String())},
+		expected, value, missingMatches,
+	).Int64()
+	return swapped == 1, err
+}
+
+// touchScript only ever moves the activity timestamp forward.
+var touchScript = redis.NewScript(`
+local current = tonumber(redis.call('GET', KEYS[1]) or '0')
+if tonumber(ARGV[1]) > current then
+	redis.call('SET', KEYS[1], ARGV[1], 'EX', ARGV[2])
+end
+return 1
+`)
+
+func (registry *redisRegistry) Touch(userID uuid.UUID, at time.Time) error {
+	return touchScript.Run(ctx, rd.RedisClient,
+		[]string{activityKey(userID.String())},
+		at.Unix(), int(activityTTL.Seconds()),
+	).Err()
+}
+
+func (registry *redisRegistry) LastActivity(userID uuid.UUID) (time.Time, error) {
+	seconds, err := rd.RedisClient.Get(ctx, activityKey(userID.String())).Int64()
+	if err == redis.Nil {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(seconds, 0), nil
+}
+
+func (registry *redisRegistry) Start(onTransition func(userID uuid.UUID, online bool)) {
+	registry.onTransition = onTransition
+
+	// Anything registered under our node ID belongs to a previous process
+	registry.reap(registry.nodeID)
+	registry.heartbeat()
+
+	go func() {
+		heartbeat := time.NewTicker(heartbeatInterval)
+		reaper := time.NewTicker(reapInterval)
+		defer heartbeat.Stop()
+		defer reaper.Stop()
+
+		for {
+			select {
+			case <-heartbeat.C:
+				registry.heartbeat()
+			case <-reaper.C:
+				registry.reapDeadNodes()
+			}
+		}
+	}()
+}
+
+func (registry *redisRegistry) heartbeat() {
+	pipe := rd.RedisClient.TxPipeline()
+	pipe.SAdd(ctx, nodesKey, registry.nodeID)
+	// A missing heartbeat key means other nodes may already have reaped us
+	alive := pipe.Exists(ctx, nodeKey(registry.nodeID))
+	pipe.Set(ctx, nodeKey(registry.nodeID), time.Now().Unix(), heartbeatTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("presence heartbeat failed: %v", err)
+		return
+	}
+
+	if alive.Val() == 0 {
+		registry.reregister()
+	}
+}
+
+// reregister restores this node's connections after its entries were reaped.
+func (registry *redisRegistry) reregister() {
+	registry.mutex.Lock()
+	connections := make(map[uuid.UUID]uuid.UUID, len(registry.connections))
+	for connectionID, userID := range registry.connections {
+		connections[connectionID] = userID
+	}
+	registry.mutex.Unlock()
+
+	for connectionID, userID := range connections {
+		cameOnline, err := registry.register(userID, connectionID)
+		if err != nil {
+			log.Printf("failed to re-register presence for user %s: %v", userID, err)
+			continue
+		}
+		if cameOnline {
+			registry.onTransition(userID, true)
+		}
+	}
+}
+
+func (registry *redisRegistry) reapDeadNodes() {
+	nodes, err := rd.RedisClient.SMembers(ctx, nodesKey).Result()
+	if err != nil {
+		log.Printf("failed to list presence nodes: %v", err)
+		return
+	}
+
+	for _, nodeID := range nodes {
+		if nodeID == registry.nodeID {
+			continue
+		}
+
+		alive, err := rd.RedisClient.Exists(ctx, nodeKey(nodeID)).Result()
+		if err != nil || alive > 0 {
+			continue
+		}
+
+		log.Printf("reaping presence of dead node %s", nodeID)
+		registry.reap(nodeID)
+		if err := rd.DestroyNodeGroups(nodeID); err != nil {
+			log.Printf("failed to destroy stream groups of node %s: %v", nodeID, err)
+		}
+	}
+}
+
+func (registry *redisRegistry) reap(nodeID string) {
+	offline, err := reapScript.Run(ctx, rd.RedisClient,
+		[]string{nodeConnectionsKey(nodeID), nodesKey},
+		nodeID,
+	).StringSlice()
+	if err != nil && err != redis.Nil {
+		log.Printf("failed to reap presence of node %s: %v", nodeID, err)
+		return
+	}
+
+	for _, id := range offline {
+		userID, err := uuid.Parse(strings.TrimSpace(id))
+		if err != nil {
+			continue
+		}
+		registry.onTransition(userID, false)
+	}
+}
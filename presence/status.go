@@ -0,0 +1,56 @@
+package presence
+
+import (
+	"time"
+)
+
+const (
+	StatusOnline    = "online"
+	StatusAway      = "away"
+	StatusDND       = "dnd"
+	StatusInvisible = "invisible"
+	StatusOffline   = "offline"
+)
+
+// Status is what a user chose to show, plus an optional custom message.
+type Status struct {
+	Status    string     `json:"status"`
+	Emoji     string     `json:"emoji,omitempty"`
+	Text      string     `json:"text,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	// Auto marks an away status set by idle detection rather than by the user
+	Auto bool `json:"auto,omitempty"`
+}
+
+func (status Status) Expired(now time.Time) bool {
+	return status.ExpiresAt != nil && now.After(*status.ExpiresAt)
+}
+
+// Equal reports whether both statuses show the same thing.
+func (status Status) Equal(other Status) bool {
+	if (status.ExpiresAt == nil) != (other.ExpiresAt == nil) {
+		return false
+	}
+	if status.ExpiresAt != nil && !status.ExpiresAt.Equal(*other.ExpiresAt) {
+		return false
+	}
+	return status.Status == other.Status && status.Emoji == other.Emoji &&
+		status.Text == other.Text && status.Auto == other.Auto
+}
+
+// Visible is the status other users see; invisible users appear offline.
+func (status Status) Visible() string {
+	if status.Status == StatusInvisible {
+		return StatusOffline
+	}
+	return status.Status
+}
+
+// IsSelectable reports whether users may pick the status themselves.
+func IsSelectable(status string) bool {
+	switch status {
+	case StatusOnline, StatusAway, StatusDND, StatusInvisible:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+package rd
+
+import (
+	"context"
+	"fmt"
+	"huddle-ws-server/broker"
+	"log"
+	"os"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// NewBroker returns the Redis broker selected by REDIS_TRANSPORT ("pubsub" or "streams").
+// InitRedis must have been called first.
+func NewBroker() (broker.Broker, error) {
+	if streamsSelected() {
+		// The consumer group is named after the node, so a hostname that changes
+		// with every container would start a fresh group and skip the backlog
+		if os.Getenv("NODE_ID") == "" {
+			return nil, fmt.Errorf("REDIS_TRANSPORT=streams requires NODE_ID")
+		}
+		return newStreamTransport(), nil
+	}
+	return newPubSubTransport(), nil
+}
+
+func streamsSelected() bool {
+	return os.Getenv("REDIS_TRANSPORT") == "streams"
+}
+
+// NodeID identifies this server instance; set NODE_ID to keep it stable across restarts.
+func NodeID() string {
+	if nodeID := os.Getenv("NODE_ID"); nodeID != "" {
+		return nodeID
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		return hostname
+	}
+	return fmt.Sprintf("node-%d", os.Getpid())
+}
+
+// pubSubTransport is fire-and-forget: nodes that are not listening miss the event.
+type pubSubTransport struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newPubSubTransport() *pubSubTransport {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &pubSubTransport{ctx: ctx, cancel: cancel}
+}
+
+func (transport *pubSubTransport) Name() string {
+	return "redis-pubsub"
+}
+
+func (transport *pubSubTransport) Publish(channel string, payload []byte) error {
+	return RedisClient.Publish(ctx, channel, payload).Err()
+}
+
+func (transport *pubSubTransport) Subscribe(channel string) (*broker.Subscription, error) {
+	if transport.ctx.Err() != nil {
+		return nil, broker.ErrClosed
+	}
+
+	subCtx, cancel := context.WithCancel(transport.ctx)
+	messages := make(chan *broker.Message)
+	go transport.supervise(subCtx, channel, messages)
+
+	return broker.NewSubscription(messages, cancel), nil
+}
+
+// supervise keeps the channel subscribed, resubscribing with backoff whenever the connection drops.
+func (transport *pubSubTransport) supervise(ctx context.Context, channel string, messages chan<- *broker.Message) {
+	defer close(messages)
+	var retry backoff
+	sub := trackListener()
+	defer sub.release()
+
+	for ctx.Err() == nil {
+		pubsub := RedisClient.Subscribe(ctx, channel)
+		stop := context.AfterFunc(ctx, func() { pubsub.Close() })
+
+		_, err := pubsub.Receive(ctx)
+		if err == nil {
+			retry.reset()
+			reportSuccess()
+			sub.up()
+			err = pump(ctx, pubsub, messages)
+		}
+
+		stop()
+		pubsub.Close()
+		if ctx.Err() != nil {
+			return
+		}
+
+		sub.down()
+		reportFailure(err)
+		log.Printf("Redis subscription to %s lost, resubscribing: %v", channel, err)
+		retry.wait(ctx)
+	}
+}
+
+func pump(ctx context.Context, pubsub *redis.PubSub, messages chan<- *broker.Message) error {
+	for {
+		msg, err := pubsub.ReceiveMessage(ctx)
+		if err != nil {
+			return err
+		}
+
+		select {
+		case messages <- broker.NewMessage(msg.Channel, msg.Payload, nil):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (transport *pubSubTransport) Ready() bool {
+	return Healthy()
+}
+
+func (transport *pubSubTransport) Close() error {
+	transport.cancel()
+	return nil
+}
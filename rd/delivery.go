@@ -0,0 +1,34 @@
+package rd
+
+import (
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Long enough for a device that was offline over a weekend to ack on reconnect
+const deliveryTTL = 7 * 24 * time.Hour
+
+func deliveryKey(messageID string) string {
+	return "delivered:" + messageID
+}
+
+// MarkDelivered records that the messages reached one of userID's devices.
+// newly[i] is false when messageIDs[i] had already been acked by that user.
+func MarkDelivered(messageIDs []string, userID string) (newly []bool, err error) {
+	pipe := RedisClient.Pipeline()
+	added := make([]*redis.IntCmd, len(messageIDs))
+	for i, messageID := range messageIDs {
+		added[i] = pipe.SAdd(ctx, deliveryKey(messageID), userID)
+		pipe.Expire(ctx, deliveryKey(messageID), deliveryTTL)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	newly = make([]bool, len(messageIDs))
+	for i, cmd := range added {
+		newly[i] = cmd.Val() > 0
+	}
+	return newly, nil
+}
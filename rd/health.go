@@ -0,0 +1,198 @@
+package rd
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	healthCheckInterval = 5 * time.Second
+	minRetryDelay       = 500 * time.Millisecond
+	maxRetryDelay       = 30 * time.Second
+)
+
+var health struct {
+	mutex     sync.Mutex
+	healthy   bool
+	everUp    bool
+	downSince time.Time
+	lastError error
+	hooks     []func(outage time.Duration)
+
+	// Supervised subscriptions that are not receiving; hooks wait for zero
+	listenersDown int
+	// Set from reconnect until the hooks have run
+	hooksPending bool
+	pendingSince time.Time
+}
+
+// listener tracks whether one supervised subscription is receiving. Its
+// state is guarded by health.mutex.
+type listener struct {
+	listening bool
+}
+
+// trackListener registers a subscription that is not yet receiving.
+func trackListener() *listener {
+	health.mutex.Lock()
+	defer health.mutex.Unlock()
+	health.listenersDown++
+	return &listener{}
+}
+
+// up marks the subscription as receiving again, which may be the last thing
+// the reconnect hooks were waiting for.
+func (sub *listener) up() {
+	health.mutex.Lock()
+	if !sub.listening {
+		sub.listening = true
+		health.listenersDown--
+	}
+	hooks, outage := takeReconnectHooks()
+	health.mutex.Unlock()
+
+	runReconnectHooks(hooks, outage)
+}
+
+func (sub *listener) down() {
+	health.mutex.Lock()
+	defer health.mutex.Unlock()
+	if sub.listening {
+		sub.listening = false
+		health.listenersDown++
+	}
+}
+
+// release stops tracking a subscription that has been closed.
+func (sub *listener) release() {
+	health.mutex.Lock()
+	if !sub.listening {
+		sub.listening = true
+		health.listenersDown--
+	}
+	hooks, outage := takeReconnectHooks()
+	health.mutex.Unlock()
+
+	runReconnectHooks(hooks, outage)
+}
+
+// Healthy reports whether Redis is currently reachable.
+func Healthy() bool {
+	health.mutex.Lock()
+	defer health.mutex.Unlock()
+	return health.healthy
+}
+
+// LastError returns the most recent Redis failure, if any.
+func LastError() error {
+	health.mutex.Lock()
+	defer health.mutex.Unlock()
+	return health.lastError
+}
+
+// OnReconnect registers a hook run with the outage length whenever Redis comes
+// back, once every subscription is receiving again.
+func OnReconnect(hook func(outage time.Duration)) {
+	health.mutex.Lock()
+	defer health.mutex.Unlock()
+	health.hooks = append(health.hooks, hook)
+}
+
+func reportFailure(err error) {
+	health.mutex.Lock()
+	defer health.mutex.Unlock()
+
+	health.lastError = err
+	if health.healthy {
+		health.healthy = false
+		health.downSince = time.Now()
+		log.Printf("Lost connection to Redis: %v", err)
+	}
+}
+
+// reportSuccess marks Redis reachable. After an outage the reconnect hooks
+// are held back until every subscription has resubscribed, so clients told
+// to refetch cannot miss events published while this node was not listening.
+func reportSuccess() {
+	health.mutex.Lock()
+	if health.healthy {
+		health.mutex.Unlock()
+		return
+	}
+
+	health.healthy = true
+	health.lastError = nil
+	reconnected := health.everUp
+	health.everUp = true
+	outage := time.Since(health.downSince)
+	if reconnected && !health.hooksPending {
+		health.hooksPending = true
+		health.pendingSince = health.downSince
+	}
+	hooks, pendingOutage := takeReconnectHooks()
+	health.mutex.Unlock()
+
+	if !reconnected {
+		log.Printf("Connected to Redis")
+		return
+	}
+
+	log.Printf("Reconnected to Redis after %s", outage.Round(time.Millisecond))
+	runReconnectHooks(hooks, pendingOutage)
+}
+
+// takeReconnectHooks returns the hooks once Redis is up and every
+// subscription is receiving again. Callers must hold health.mutex.
+func takeReconnectHooks() ([]func(time.Duration), time.Duration) {
+	if !health.hooksPending || !health.healthy || health.listenersDown > 0 {
+		return nil, 0
+	}
+	health.hooksPending = false
+	return append([]func(time.Duration){}, health.hooks...), time.Since(health.pendingSince)
+}
+
+func runReconnectHooks(hooks []func(time.Duration), outage time.Duration) {
+	for _, hook := range hooks {
+		hook(outage)
+	}
+}
+
+// monitor pings Redis so an outage is noticed even when no subscription is reading.
+func monitor() {
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := RedisClient.Ping(ctx).Err(); err != nil {
+			reportFailure(err)
+		} else {
+			reportSuccess()
+		}
+	}
+}
+
+// backoff doubles the retry delay after every failure up to maxRetryDelay.
+type backoff struct {
+	delay time.Duration
+}
+
+func (retry *backoff) wait(ctx context.Context) {
+	if retry.delay == 0 {
+		retry.delay = minRetryDelay
+	}
+
+	timer := time.NewTimer(retry.delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	retry.delay = min(retry.delay*2, maxRetryDelay)
+}
+
+func (retry *backoff) reset() {
+	retry.delay = 0
+}
@@ -12,25 +12,24 @@ var ctx = context.Background()
 
 var RedisClient *redis.Client
 
+// InitRedis connects to REDIS_URL. If Redis is down at boot the server still
+// starts; subscriptions keep retrying and Healthy reports the outage.
 func InitRedis() {
 	RedisClient = redis.NewClient(&redis.Options{
 		Addr: os.Getenv("REDIS_URL"),
 	})
 
-	ctx := context.Background()
-	pong, err := RedisClient.Ping(ctx).Result()
-	if err != nil {
-		log.Fatalf("Failed to connect to Redis: %v", err)
+	if err := RedisClient.Ping(ctx).Err(); err != nil {
+		log.Printf("Failed to connect to Redis, retrying in the background: %v", err)
+		reportFailure(err)
+	} else {
+		reportSuccess()
 	}
-	log.Printf("Connected to Redis: %s", pong)
-}
 
-// Publish message to Redis
-func Publish(channel string, payload interface{}) error {
-	return RedisClient.Publish(ctx, channel, payload).Err()
+	go monitor()
 }
 
-func Subscribe(channel string) <-chan *redis.Message {
-	pubsub := RedisClient.Subscribe(ctx, channel)
-	return pubsub.Channel()
+// Available reports whether Redis was configured; Redis-only features turn off without it.
+func Available() bool {
+	return RedisClient != nil
 }
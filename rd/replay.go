@@ -0,0 +1,114 @@
+package rd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// Replay log entries kept per topic; older gaps require a full resync
+	ReplayLogLength = 1000
+	replayLogTTL    = 24 * 60 * 60
+	// Every node sees the same event, so the first node to stamp its ID wins.
+	// Long enough to cover a stream consumer catching up after an outage.
+	sequenceClaimTTL = 60 * 60
+	// Pub/sub reaches every node at once, so a payload hash only has to match
+	// briefly; the same payload published again later gets its own number
+	payloadClaimTTL = 60
+)
+
+// sequenceScript stamps an event with the topic's next sequence number and
+// appends it to the replay stream exactly once, however many nodes call it.
+var sequenceScript = redis.NewScript(`
+local seq = redis.call('GET', KEYS[1])
+if seq then
+	return tonumber(seq)
+end
+seq = redis.call('INCR', KEYS[2])
+redis.call('XADD', KEYS[3], 'MAXLEN', '~', ARGV[3], seq .. '-0', 'payload', ARGV[1])
+redis.call('EXPIRE', KEYS[3], ARGV[4])
+redis.call('SET', KEYS[1], seq, 'EX', ARGV[2])
+return seq
+`)
+
+type ReplayEntry struct {
+	Seq     int64
+	Payload string
+}
+
+func sequenceKey(topic string) string {
+	return "seq:" + topic
+}
+
+func replayKey(topic string) string {
+	return "replay:" + topic
+}
+
+// Sequence returns the sequence number assigned to the event on topic.
+// eventID is the same on every node, so two events with identical payloads
+// still get their own numbers.
+func Sequence(topic string, eventID string, payload []byte) (int64, error) {
+	return sequence(topic, eventID, payload, sequenceClaimTTL)
+}
+
+// SequencePayload is Sequence for events published without an ID, claimed by
+// a hash of the payload. Only transports that deliver to every node at about
+// the same time, like pub/sub, can rely on it.
+func SequencePayload(topic string, payload []byte) (int64, error) {
+	digest := sha256.Sum256(payload)
+	return sequence(topic, "sha256:"+hex.EncodeToString(digest[:]), payload, payloadClaimTTL)
+}
+
+func sequence(topic string, claim string, payload []byte, claimTTL int) (int64, error) {
+	claimKey := "seq:claim:" + topic + ":" + claim
+
+	return sequenceScript.Run(ctx, RedisClient,
+		[]string{claimKey, sequenceKey(topic), replayKey(topic)},
+		payload, claimTTL, ReplayLogLength, replayLogTTL,
+	).Int64()
+}
+
+// Replay returns the entries after afterSeq. ok is false when the log no
+// longer covers the gap and the caller has to resync from scratch.
+func Replay(topic string, afterSeq int64) (entries []ReplayEntry, ok bool, err error) {
+	current, err := RedisClient.Get(ctx, sequenceKey(topic)).Int64()
+	if err == redis.Nil {
+		return nil, afterSeq == 0, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	if afterSeq >= current {
+		return nil, afterSeq == current, nil
+	}
+	if current-afterSeq > ReplayLogLength {
+		return nil, false, nil
+	}
+
+	messages, err := RedisClient.XRange(ctx, replayKey(topic), fmt.Sprintf("%d-0", afterSeq+1), "+").Result()
+	if err != nil {
+		return nil, false, err
+	}
+
+	for _, message := range messages {
+		seq, err := strconv.ParseInt(strings.TrimSuffix(message.ID, "-0"), 10, 64)
+		if err != nil {
+			continue
+		}
+		payload, _ := message.Values["payload"].(string)
+		entries = append(entries, ReplayEntry{Seq: seq, Payload: payload})
+	}
+
+	// A trimmed or expired log shows up as a hole right after afterSeq
+	if len(entries) == 0 || entries[0].Seq != afterSeq+1 {
+		return nil, false, nil
+	}
+
+	return entries, true, nil
+}
@@ -0,0 +1,249 @@
+package rd
+
+import (
+	"context"
+	"fmt"
+	"huddle-ws-server/broker"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	streamReadCount = 100
+	streamBlock     = 5 * time.Second
+	streamClaimIdle = time.Minute
+)
+
+// streamTransport delivers events through Redis Streams. Every node needs
+// every event, so each node reads through its own consumer group named after
+// NODE_ID. A restarted node resumes from where its group left off and claims
+// entries its previous process read but never acknowledged.
+type streamTransport struct {
+	group    string
+	consumer string
+	maxLen   int64
+	ctx      context.Context
+	cancel   context.CancelFunc
+}
+
+func newStreamTransport() *streamTransport {
+	maxLen := int64(10000)
+	if value, err := strconv.ParseInt(os.Getenv("REDIS_STREAM_MAXLEN"), 10, 64); err == nil && value > 0 {
+		maxLen = value
+	}
+
+	nodeID := NodeID()
+	ctx, cancel := context.WithCancel(context.Background())
+	return &streamTransport{
+		group:    nodeID,
+		consumer: fmt.Sprintf("%s-%d", nodeID, os.Getpid()),
+		maxLen:   maxLen,
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+func streamKey(channel string) string {
+	return "stream:" + channel
+}
+
+func (transport *streamTransport) Name() string {
+	return "redis-streams"
+}
+
+func (transport *streamTransport) Publish(channel string, payload []byte) error {
+	return RedisClient.XAdd(ctx, &redis.XAddArgs{
+		Stream: streamKey(channel),
+		MaxLen: transport.maxLen,
+		Approx: true,
+		Values: map[string]interface{}{"payload": payload},
+	}).Err()
+}
+
+func (transport *streamTransport) Subscribe(channel string) (*broker.Subscription, error) {
+	if transport.ctx.Err() != nil {
+		return nil, broker.ErrClosed
+	}
+
+	subCtx, cancel := context.WithCancel(transport.ctx)
+	messages := make(chan *broker.Message)
+	go transport.consume(subCtx, channel, messages)
+
+	return broker.NewSubscription(messages, cancel), nil
+}
+
+func (transport *streamTransport) Close() error {
+	transport.cancel()
+	return nil
+}
+
+func (transport *streamTransport) ensureGroup(stream string) error {
+	// "$" means a brand new node starts with live events instead of the whole backlog
+	err := RedisClient.XGroupCreateMkStream(ctx, stream, transport.group, "$").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+	return nil
+}
+
+// reclaim moves entries left pending by dead consumers of this group to this
+// consumer and returns how many it claimed.
+func (transport *streamTransport) reclaim(stream string) (int, error) {
+	claimed := 0
+	start := "0-0"
+	for {
+		ids, next, err := RedisClient.XAutoClaimJustID(ctx, &redis.XAutoClaimArgs{
+			Stream:   stream,
+			Group:    transport.group,
+			Consumer: transport.consumer,
+			MinIdle:  streamClaimIdle,
+			Start:    start,
+			Count:    streamReadCount,
+		}).Result()
+		if err != nil {
+			return claimed, err
+		}
+		claimed += len(ids)
+		if next == "0-0" {
+			return claimed, nil
+		}
+		start = next
+	}
+}
+
+// DestroyNodeGroups removes the consumer groups a reaped node left on every
+// stream so Redis stops tracking its pending entries. A node that comes back
+// under the same NODE_ID afterwards starts a new group with live events.
+func DestroyNodeGroups(nodeID string) error {
+	if !streamsSelected() {
+		return nil
+	}
+
+	iter := RedisClient.Scan(ctx, 0, streamKey("*"), streamReadCount).Iterator()
+	for iter.Next(ctx) {
+		if err := RedisClient.XGroupDestroy(ctx, iter.Val(), nodeID).Err(); err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
+
+func (transport *streamTransport) Ready() bool {
+	return Healthy()
+}
+
+func (transport *streamTransport) setup(ctx context.Context, stream string) {
+	var retry backoff
+
+	for ctx.Err() == nil {
+		err := transport.ensureGroup(stream)
+		if err == nil {
+			if _, err := transport.reclaim(stream); err != nil {
+				log.Printf("failed to reclaim pending entries on %s: %v", stream, err)
+			}
+			return
+		}
+
+		reportFailure(err)
+		log.Printf("failed to create consumer group on %s: %v", stream, err)
+		retry.wait(ctx)
+	}
+}
+
+func (transport *streamTransport) consume(ctx context.Context, channel string, messages chan<- *broker.Message) {
+	defer close(messages)
+	stream := streamKey(channel)
+	sub := trackListener()
+	defer sub.release()
+	transport.setup(ctx, stream)
+
+	// Drain this consumer's pending entries first, then switch to new ones
+	lastID := "0"
+	lastReclaim := time.Now()
+	var retry backoff
+
+	// Entries handed to the handler but not yet acked are still in the pending
+	// list, so re-reading it must not deliver them a second time
+	var inFlight sync.Map
+
+	for ctx.Err() == nil {
+		if time.Since(lastReclaim) > streamClaimIdle {
+			claimed, err := transport.reclaim(stream)
+			if err != nil {
+				log.Printf("failed to reclaim pending entries on %s: %v", stream, err)
+			} else if claimed > 0 {
+				lastID = "0"
+			}
+			lastReclaim = time.Now()
+		}
+
+		streams, err := RedisClient.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    transport.group,
+			Consumer: transport.consumer,
+			Streams:  []string{stream, lastID},
+			Count:    streamReadCount,
+			Block:    streamBlock,
+		}).Result()
+		if err == redis.Nil {
+			reportSuccess()
+			sub.up()
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			sub.down()
+			reportFailure(err)
+			log.Printf("failed to read from %s: %v", stream, err)
+			retry.wait(ctx)
+
+			// A Redis restart without persistence loses the group along with the stream
+			if strings.HasPrefix(err.Error(), "NOGROUP") {
+				transport.setup(ctx, stream)
+				lastID = "0"
+			}
+			continue
+		}
+		retry.reset()
+		reportSuccess()
+		sub.up()
+
+		entries := streams[0].Messages
+		if lastID != ">" {
+			if len(entries) == 0 {
+				lastID = ">"
+				continue
+			}
+			lastID = entries[len(entries)-1].ID
+		}
+
+		for _, entry := range entries {
+			id := entry.ID
+			if _, handling := inFlight.LoadOrStore(id, struct{}{}); handling {
+				continue
+			}
+
+			payload, _ := entry.Values["payload"].(string)
+			message := broker.NewMessage(channel, payload, func() {
+				defer inFlight.Delete(id)
+				if err := RedisClient.XAck(context.Background(), stream, transport.group, id).Err(); err != nil {
+					log.Printf("failed to ack %s on %s: %v", id, stream, err)
+				}
+			})
+			message.ID = id
+
+			select {
+			case messages <- message:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
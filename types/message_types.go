@@ -1,6 +1,8 @@
 package types
 
 import (
+	"time"
+
 	"github.com/google/uuid"
 )
 
@@ -60,12 +62,139 @@ type Message struct {
 	Event          string                `json:"event,omitempty"`
 	Reaction       *MessageReactionEvent `json:"reaction,omitempty"`
 	Data           interface{}           `json:"data,omitempty"`
+
+	// Set when the event originated from a socket, so that socket is not echoed to
+	OriginConnectionID *uuid.UUID `json:"originConnectionId,omitempty"`
+	// Client-generated ID letting the sender match the echo to its optimistic copy
+	ClientMessageID string `json:"clientMessageId,omitempty"`
+	// Unique per published event; events without one are sequenced by broker entry ID or payload
+	EventID string `json:"eventId,omitempty"`
+	// Per channel/conversation sequence number, zero for unsequenced events
+	Seq int64 `json:"seq,omitempty"`
+
+	// Route picks the recipients. When empty it is inferred from channelId,
+	// conversationId or teamId; a message with none of them, or with both a
+	// channelId and a teamId, is rejected.
+	Route   string      `json:"route,omitempty"`
+	UserIds []uuid.UUID `json:"userIds,omitempty"`
+	TeamID  *uuid.UUID  `json:"teamId,omitempty"`
+	// Required for RouteGlobal
+	Admin *AdminSignature `json:"admin,omitempty"`
+}
+
+const (
+	RouteChannel      = "channel"
+	RouteConversation = "conversation"
+	RouteUsers        = "users"
+	RouteTeam         = "team"
+	RouteGlobal       = "global"
+)
+
+// AdminSignature authorizes a global announcement. Signature is the hex
+// HMAC-SHA256, keyed with WS_BROADCAST_SECRET, of the whole message encoded
+// as JSON with sorted keys, no seq and an empty signature (see ws.SignAnnouncement).
+type AdminSignature struct {
+	IssuedAt  int64  `json:"issuedAt"` // unix seconds
+	Signature string `json:"signature"`
+}
+
+type SendMessagePayload struct {
+	Type             string     `json:"type"`
+	ConversationId   *uuid.UUID `json:"conversationId"`
+	ChannelId        *uuid.UUID `json:"channelId"`
+	Content          string     `json:"content"`
+	ContentType      string     `json:"contentType,omitempty"`
+	ReplyToMessageId *uuid.UUID `json:"replyToMessageId,omitempty"`
+	ClientMessageId  string     `json:"clientMessageId,omitempty"`
+	RequestId        string     `json:"requestId,omitempty"`
+}
+
+// MessageSentPayload acknowledges a send_message frame to the connection that sent it.
+type MessageSentPayload struct {
+	MessageId       string `json:"messageId"`
+	ClientMessageId string `json:"clientMessageId,omitempty"`
+	CreatedAt       string `json:"createdAt"`
+	RequestId       string `json:"requestId,omitempty"`
+}
+
+type EditMessagePayload struct {
+	Type      string    `json:"type"`
+	MessageId uuid.UUID `json:"messageId"`
+	Content   string    `json:"content"`
+	RequestId string    `json:"requestId,omitempty"`
+}
+
+type DeleteMessagePayload struct {
+	Type      string    `json:"type"`
+	MessageId uuid.UUID `json:"messageId"`
+	RequestId string    `json:"requestId,omitempty"`
+}
+
+type MarkReadPayload struct {
+	Type           string     `json:"type"`
+	ConversationId *uuid.UUID `json:"conversationId"`
+	ChannelId      *uuid.UUID `json:"channelId"`
+	// Defaults to the newest message
+	MessageId *uuid.UUID `json:"messageId,omitempty"`
+	RequestId string     `json:"requestId,omitempty"`
+}
+
+type ReadReceiptPayload struct {
+	UserId         string     `json:"userId"`
+	ConversationId *uuid.UUID `json:"conversationId,omitempty"`
+	ChannelId      *uuid.UUID `json:"channelId,omitempty"`
+	MessageId      *uuid.UUID `json:"messageId,omitempty"`
+	ReadAt         time.Time  `json:"readAt"`
+}
+
+// UnreadCount stops counting at 100, so Unread == 100 means "100 or more".
+type UnreadCount struct {
+	ConversationId *uuid.UUID `json:"conversationId,omitempty"`
+	ChannelId      *uuid.UUID `json:"channelId,omitempty"`
+	Unread         int64      `json:"unread"`
+}
+
+type UnreadCountsPayload struct {
+	Counts []UnreadCount `json:"counts"`
+}
+
+// DeliveryAckPayload is sent by clients for messages that reached the device.
+type DeliveryAckPayload struct {
+	Type       string      `json:"type"`
+	MessageIds []uuid.UUID `json:"messageIds"`
+}
+
+type DeliveredPayload struct {
+	MessageId      uuid.UUID `json:"messageId"`
+	ConversationId uuid.UUID `json:"conversationId"`
+	UserId         uuid.UUID `json:"userId"`
+	DeliveredAt    time.Time `json:"deliveredAt"`
+}
+
+// UserEvent is published on user_events to reach every session of one user,
+// whichever node it is connected to.
+type UserEvent struct {
+	UserId uuid.UUID `json:"userId"`
+	Event  Message   `json:"event"`
+}
+
+type ConnectedPayload struct {
+	ConnectionId uuid.UUID `json:"connectionId"`
 }
 
 type MessageReactionEvent struct {
 	MessageID string                  `json:"messageId"`
 	Reaction  MessageReactionResponse `json:"reaction"`
 	Action    string                  `json:"action"` // "add" or "remove"
+	// Reactions is the message's full tally after the change
+	Reactions []ReactionResponse `json:"reactions"`
+}
+
+type ReactionPayload struct {
+	Type      string    `json:"type"` // "add_reaction" or "remove_reaction"
+	MessageId uuid.UUID `json:"messageId"`
+	Emoji     string    `json:"emoji"`
+	RequestId string    `json:"requestId,omitempty"`
 }
 
 type MessageReactionResponse struct {
@@ -85,3 +214,99 @@ type TypingPayload struct {
 	UserAvatar      *string    `json:"userAvatar"`
 	UserDisplayName *string    `json:"userDisplayName"`
 }
+
+type SubscriptionPayload struct {
+	Type           string     `json:"type"` // "subscribe" or "unsubscribe"
+	ConversationId *uuid.UUID `json:"conversationId"`
+	ChannelId      *uuid.UUID `json:"channelId"`
+	RequestId      string     `json:"requestId,omitempty"`
+}
+
+type AckPayload struct {
+	RequestId string `json:"requestId,omitempty"`
+}
+
+const (
+	ErrorCodeInvalidRequest = "invalid_request"
+	ErrorCodeForbidden      = "forbidden"
+	ErrorCodeInternal       = "internal_error"
+)
+
+type ErrorPayload struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestId string `json:"requestId,omitempty"`
+}
+
+// MembershipEvent is published on the membership_events channel whenever a
+// user gains or loses access to a team, channel or conversation.
+type MembershipEvent struct {
+	Action          string      `json:"action"` // "add", "remove" or "role_changed" (teamId required)
+	UserId          uuid.UUID   `json:"userId"`
+	TeamId          *uuid.UUID  `json:"teamId,omitempty"`
+	ChannelIds      []uuid.UUID `json:"channelIds,omitempty"`
+	ConversationIds []uuid.UUID `json:"conversationIds,omitempty"`
+}
+
+// ChannelEvent is published on channel_events when a channel is archived,
+// unarchived or has its AllowedRoles changed.
+type ChannelEvent struct {
+	Action    string    `json:"action"` // "archived", "unarchived" or "updated"
+	ChannelId uuid.UUID `json:"channelId"`
+	TeamId    uuid.UUID `json:"teamId"`
+}
+
+// UserUpdatedEvent is published on the user_updated channel after a profile
+// change so every node drops its cached copy.
+type UserUpdatedEvent struct {
+	UserId uuid.UUID `json:"userId"`
+}
+
+type SubscriptionChange struct {
+	Action          string      `json:"action"`
+	TeamId          *uuid.UUID  `json:"teamId,omitempty"`
+	ChannelIds      []uuid.UUID `json:"channelIds,omitempty"`
+	ConversationIds []uuid.UUID `json:"conversationIds,omitempty"`
+}
+
+type ResumeTopic struct {
+	ConversationId *uuid.UUID `json:"conversationId"`
+	ChannelId      *uuid.UUID `json:"channelId"`
+	LastSeq        int64      `json:"lastSeq"`
+}
+
+type ResumePayload struct {
+	Type      string        `json:"type"`
+	Topics    []ResumeTopic `json:"topics"`
+	RequestId string        `json:"requestId,omitempty"`
+}
+
+type SetStatusPayload struct {
+	Type      string     `json:"type"`
+	Status    string     `json:"status"` // online, away, dnd or invisible
+	Emoji     string     `json:"emoji,omitempty"`
+	Text      string     `json:"text,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	RequestId string     `json:"requestId,omitempty"`
+}
+
+// UserStatusPayload is published on user_online_status and delivered as user_status data.
+type UserStatusPayload struct {
+	UserId     string     `json:"userId"`
+	Status     string     `json:"status"`
+	Emoji      string     `json:"emoji,omitempty"`
+	Text       string     `json:"text,omitempty"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	LastActive *time.Time `json:"lastActive,omitempty"`
+}
+
+type GetPresencePayload struct {
+	Type      string      `json:"type"`
+	UserIds   []uuid.UUID `json:"userIds"`
+	RequestId string      `json:"requestId,omitempty"`
+}
+
+type PresenceResult struct {
+	Users     []UserStatusPayload `json:"users"`
+	RequestId string              `json:"requestId,omitempty"`
+}
@@ -0,0 +1,128 @@
+package ws
+
+import (
+	"huddle-ws-server/database"
+	"huddle-ws-server/models"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	audienceTTL        = 5 * time.Minute
+	audienceMaxEntries = 50000
+)
+
+// audienceCache remembers who may see a user's presence: teammates and DM
+// partners. Entries expire after audienceTTL and are dropped early when
+// membership changes.
+type audienceCache struct {
+	mutex   sync.Mutex
+	entries map[uuid.UUID]audienceEntry
+}
+
+type audienceEntry struct {
+	users   []uuid.UUID
+	expires time.Time
+}
+
+var presenceAudience = &audienceCache{entries: make(map[uuid.UUID]audienceEntry)}
+
+func (cache *audienceCache) get(userID uuid.UUID) ([]uuid.UUID, error) {
+	cache.mutex.Lock()
+	entry, ok := cache.entries[userID]
+	cache.mutex.Unlock()
+
+	if ok && time.Now().Before(entry.expires) {
+		return entry.users, nil
+	}
+
+	users, err := loadAudience(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if len(cache.entries) >= audienceMaxEntries {
+		cache.purgeExpired()
+	}
+	cache.entries[userID] = audienceEntry{users: users, expires: time.Now().Add(audienceTTL)}
+
+	return users, nil
+}
+
+// purgeExpired must be called with the mutex held.
+func (cache *audienceCache) purgeExpired() {
+	now := time.Now()
+	for userID, entry := range cache.entries {
+		if now.After(entry.expires) {
+			delete(cache.entries, userID)
+		}
+	}
+	// Everything is still fresh, so start over rather than grow without bound
+	if len(cache.entries) >= audienceMaxEntries {
+		cache.entries = make(map[uuid.UUID]audienceEntry)
+	}
+}
+
+func (cache *audienceCache) invalidate(userIDs ...uuid.UUID) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	for _, userID := range userIDs {
+		delete(cache.entries, userID)
+	}
+}
+
+func (cache *audienceCache) invalidateAll() {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	cache.entries = make(map[uuid.UUID]audienceEntry)
+}
+
+// loadAudience returns the user, everyone sharing a team with them and their DM partners.
+func loadAudience(userID uuid.UUID) ([]uuid.UUID, error) {
+	var users []uuid.UUID
+	err := database.DB.Raw(`
+		SELECT teammates.user_id FROM team_members AS mine
+		JOIN team_members AS teammates ON teammates.team_id = mine.team_id
+		WHERE mine.user_id = ? AND mine.deleted_at IS NULL AND teammates.deleted_at IS NULL
+		UNION
+		SELECT CASE WHEN user1_id = ? THEN user2_id ELSE user1_id END FROM conversations
+		WHERE (user1_id = ? OR user2_id = ?) AND deleted_at IS NULL
+		UNION
+		SELECT ?::uuid`,
+		userID, userID, userID, userID, userID,
+	).Scan(&users).Error
+
+	return users, err
+}
+
+// InvalidateAudience drops cached presence audiences affected by a membership change.
+// A team change can affect every member's audience, so it clears everything.
+func InvalidateAudience(userID uuid.UUID, teamChanged bool, conversationIDs []uuid.UUID) {
+	if teamChanged {
+		presenceAudience.invalidateAll()
+		return
+	}
+
+	affected := []uuid.UUID{userID}
+	if len(conversationIDs) > 0 {
+		var conversations []models.Conversation
+		if err := database.DB.
+			Where("id IN ?", conversationIDs).
+			Find(&conversations).Error; err != nil {
+			presenceAudience.invalidateAll()
+			return
+		}
+		for _, conversation := range conversations {
+			affected = append(affected, conversation.User1ID, conversation.User2ID)
+		}
+	}
+
+	presenceAudience.invalidate(affected...)
+}
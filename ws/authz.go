@@ -0,0 +1,76 @@
+package ws
+
+import (
+	"huddle-ws-server/database"
+	"huddle-ws-server/models"
+
+	"github.com/google/uuid"
+)
+
+// canAccessChannel reports whether the user may subscribe to the channel.
+func canAccessChannel(userID uuid.UUID, channelID uuid.UUID) (bool, error) {
+	access, err := loadChannelAccess(userID, channelID)
+	if err != nil || access == nil {
+		return false, err
+	}
+	return access.canRead(), nil
+}
+
+// canAccessConversation reports whether the user is one of the conversation's participants.
+func canAccessConversation(userID uuid.UUID, conversationID uuid.UUID) (bool, error) {
+	var count int64
+	err := database.DB.Model(&models.Conversation{}).
+		Where("id = ? AND (user1_id = ? OR user2_id = ?)", conversationID, userID, userID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// channelAccess is a user's standing in a team channel.
+type channelAccess struct {
+	TeamID         uuid.UUID
+	ConversationID uuid.UUID
+	IsArchived     bool
+	ReadOnly       bool
+	Role           string
+	RoleAllowed    bool // the role is in AllowedRoles, or the channel has none
+}
+
+// isPrivileged reports whether the team role sees every channel and may post in read-only ones.
+func isPrivileged(role string) bool {
+	return role == "owner" || role == "admin"
+}
+
+// loadChannelAccess returns nil when the channel does not exist or the user is not in its team.
+func loadChannelAccess(userID uuid.UUID, channelID uuid.UUID) (*channelAccess, error) {
+	var access []channelAccess
+	err := database.DB.Raw(`
+		SELECT team_channels.team_id, team_channels.conversation_id,
+			team_channels.is_archived, team_channels.read_only, team_members.role,
+			(COALESCE(cardinality(team_channels.allowed_roles), 0) = 0
+				OR team_members.role = ANY(team_channels.allowed_roles)) AS role_allowed
+		FROM team_channels
+		JOIN team_members ON team_members.team_id = team_channels.team_id
+		WHERE team_channels.id = ? AND team_members.user_id = ?
+			AND team_channels.deleted_at IS NULL AND team_members.deleted_at IS NULL
+	`, channelID, userID).Scan(&access).Error
+	if err != nil || len(access) == 0 {
+		return nil, err
+	}
+	return &access[0], nil
+}
+
+// canRead hides archived channels and those whose AllowedRoles exclude the user.
+func (access *channelAccess) canRead() bool {
+	return !access.IsArchived && (access.RoleAllowed || isPrivileged(access.Role))
+}
+
+// canPost applies IsArchived, AllowedRoles and ReadOnly to the user's role.
+func (access *channelAccess) canPost() bool {
+	if access.IsArchived {
+		return false
+	}
+	if isPrivileged(access.Role) {
+		return true
+	}
+	return access.RoleAllowed && !access.ReadOnly
+}
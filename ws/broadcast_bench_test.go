@@ -0,0 +1,91 @@
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+	"huddle-ws-server/types"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// newBenchManager registers clientCount clients spread over channelCount
+// channels plus one five-person conversation.
+func newBenchManager(clientCount, channelCount int) (*Manager, []uuid.UUID, uuid.UUID) {
+	manager := NewManager(Config{Shards: 16, SendQueueSize: 1, OverflowPolicy: DropNewest})
+
+	channels := make([]uuid.UUID, channelCount)
+	for i := range channels {
+		channels[i] = uuid.New()
+	}
+	conversationID := uuid.New()
+
+	for i := 0; i < clientCount; i++ {
+		client := manager.NewClient(nil, uuid.New())
+		client.Channels[channels[i%channelCount]] = true
+		if i < 5 {
+			client.DirectMessages[conversationID] = true
+		}
+		manager.shardFor(client.UserId).addClient(client)
+	}
+
+	return manager, channels, conversationID
+}
+
+// scanBroadcast is the pre-index fan-out that checked every client.
+func scanBroadcast(m *Manager, msg types.Message) {
+	frame, _ := json.Marshal(msg)
+
+	for _, shard := range m.shards {
+		shard.mutex.RLock()
+		for client := range shard.clients {
+			if client.UserId.String() == msg.Message.SenderID {
+				continue
+			}
+			if msg.ChannelID != nil && !client.Channels[*msg.ChannelID] {
+				continue
+			}
+			if msg.ConversationID != nil && !client.DirectMessages[*msg.ConversationID] {
+				continue
+			}
+			client.enqueue(frame)
+		}
+		shard.mutex.RUnlock()
+	}
+}
+
+func BenchmarkBroadcastConversation(b *testing.B) {
+	for _, clientCount := range []int{1000, 10000, 50000} {
+		manager, _, conversationID := newBenchManager(clientCount, 100)
+		msg := types.Message{Type: "new_message", ConversationID: &conversationID}
+
+		b.Run(fmt.Sprintf("scan/%d", clientCount), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				scanBroadcast(manager, msg)
+			}
+		})
+		b.Run(fmt.Sprintf("index/%d", clientCount), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				manager.BroadcastMessage(msg)
+			}
+		})
+	}
+}
+
+func BenchmarkBroadcastChannel(b *testing.B) {
+	for _, clientCount := range []int{1000, 10000, 50000} {
+		manager, channels, _ := newBenchManager(clientCount, 100)
+		msg := types.Message{Type: "new_message", ChannelID: &channels[0]}
+
+		b.Run(fmt.Sprintf("scan/%d", clientCount), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				scanBroadcast(manager, msg)
+			}
+		})
+		b.Run(fmt.Sprintf("index/%d", clientCount), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				manager.BroadcastMessage(msg)
+			}
+		})
+	}
+}
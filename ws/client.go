@@ -0,0 +1,240 @@
+package ws
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/websocket/v2"
+	"github.com/google/uuid"
+)
+
+const (
+	writeWait  = 10 * time.Second
+	pingPeriod = 30 * time.Second
+)
+
+type Client struct {
+	Connection     *websocket.Conn
+	ConnectionId   uuid.UUID
+	UserId         uuid.UUID
+	Channels       map[uuid.UUID]bool
+	DirectMessages map[uuid.UUID]bool
+	Teams          map[uuid.UUID]bool
+
+	manager    *Manager
+	send       chan []byte
+	sendMutex  sync.Mutex
+	done       chan struct{}
+	writerDone chan struct{}
+	closeOnce  sync.Once
+	closeCode  int
+	dropped    atomic.Uint64
+
+	// Unix nanoseconds of the last frame received from the client
+	lastActivity atomic.Int64
+
+	// While a resume is replaying, live frames wait in held
+	replaying bool
+	held      []outbound
+}
+
+// outbound is a queued frame; topic and seq are only set for sequenced events.
+type outbound struct {
+	frame []byte
+	topic string
+	seq   int64
+}
+
+func (manager *Manager) NewClient(conn *websocket.Conn, userID uuid.UUID) *Client {
+	client := &Client{
+		Connection:     conn,
+		ConnectionId:   uuid.New(),
+		UserId:         userID,
+		Channels:       make(map[uuid.UUID]bool),
+		DirectMessages: make(map[uuid.UUID]bool),
+		Teams:          make(map[uuid.UUID]bool),
+		manager:        manager,
+		send:           make(chan []byte, manager.config.SendQueueSize),
+		done:           make(chan struct{}),
+		writerDone:     make(chan struct{}),
+	}
+	client.lastActivity.Store(time.Now().UnixNano())
+	return client
+}
+
+// Send marshals v and queues it for the writer goroutine.
+func (client *Client) Send(v interface{}) error {
+	frame, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	client.enqueue(frame)
+	return nil
+}
+
+// Dropped returns the number of frames this client lost to backpressure.
+func (client *Client) Dropped() uint64 {
+	return client.dropped.Load()
+}
+
+func (client *Client) enqueue(frame []byte) bool {
+	return client.enqueueOutbound(outbound{frame: frame})
+}
+
+// enqueueOutbound never blocks; when the queue is full the manager's overflow policy applies.
+func (client *Client) enqueueOutbound(out outbound) bool {
+	client.sendMutex.Lock()
+	defer client.sendMutex.Unlock()
+
+	if client.isClosed() {
+		return false
+	}
+
+	// Live traffic waits behind a replay so the client sees events in order
+	if client.replaying {
+		if len(client.held) >= client.manager.config.SendQueueSize {
+			client.held = client.held[1:]
+			client.recordDrop()
+		}
+		client.held = append(client.held, out)
+		return true
+	}
+
+	return client.push(out.frame)
+}
+
+// beginReplay holds live frames until endReplay.
+func (client *Client) beginReplay() {
+	client.sendMutex.Lock()
+	defer client.sendMutex.Unlock()
+	client.replaying = true
+}
+
+// enqueueReplay queues a replayed frame ahead of any held live traffic. A
+// replay can be far longer than the queue, so it waits for the writer instead
+// of applying the overflow policy. sendMutex is not needed: while replaying,
+// every other frame goes to held, so nothing else writes to send.
+func (client *Client) enqueueReplay(frame []byte) bool {
+	select {
+	case client.send <- frame:
+		return true
+	case <-client.done:
+		return false
+	}
+}
+
+// endReplay releases held frames, skipping events the replay already covered.
+func (client *Client) endReplay(replayed map[string]int64) {
+	client.sendMutex.Lock()
+	defer client.sendMutex.Unlock()
+
+	held := client.held
+	client.held = nil
+	client.replaying = false
+
+	for _, out := range held {
+		if out.seq != 0 && out.seq <= replayed[out.topic] {
+			continue
+		}
+		if client.isClosed() {
+			return
+		}
+		client.push(out.frame)
+	}
+}
+
+func (client *Client) isClosed() bool {
+	select {
+	case <-client.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// push applies the overflow policy. Callers must hold sendMutex.
+func (client *Client) push(frame []byte) bool {
+	select {
+	case client.send <- frame:
+		return true
+	default:
+	}
+
+	config := client.manager.config
+	switch config.OverflowPolicy {
+	case DropOldest:
+		select {
+		case <-client.send:
+			client.recordDrop()
+		default:
+		}
+		select {
+		case client.send <- frame:
+			return true
+		default:
+			client.recordDrop()
+			return false
+		}
+	case Disconnect:
+		client.recordDrop()
+		log.Printf("send queue full for user %s, disconnecting", client.UserId)
+		client.closeWithCode(config.OverflowCloseCode)
+		return false
+	default:
+		client.recordDrop()
+		return false
+	}
+}
+
+func (client *Client) recordDrop() {
+	client.dropped.Add(1)
+	client.manager.droppedFrames.Add(1)
+}
+
+// close stops the writer goroutine, which in turn closes the connection.
+func (client *Client) close() {
+	client.closeWithCode(0)
+}
+
+func (client *Client) closeWithCode(code int) {
+	client.closeOnce.Do(func() {
+		client.closeCode = code
+		close(client.done)
+	})
+}
+
+// writePump is the only goroutine that writes data frames to the connection.
+func (client *Client) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		client.close()
+		client.Connection.Close()
+		close(client.writerDone)
+	}()
+
+	for {
+		select {
+		case frame := <-client.send:
+			client.Connection.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := client.Connection.WriteMessage(websocket.TextMessage, frame); err != nil {
+				log.Printf("write error for user %s: %v", client.UserId, err)
+				return
+			}
+		case <-ticker.C:
+			if err := client.Connection.WriteControl(websocket.PingMessage, []byte{}, time.Now().Add(time.Second)); err != nil {
+				log.Printf("ping error: %v", err)
+				return
+			}
+		case <-client.done:
+			if client.closeCode != 0 {
+				message := websocket.FormatCloseMessage(client.closeCode, "send queue overflow")
+				client.Connection.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
+			}
+			return
+		}
+	}
+}
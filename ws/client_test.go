@@ -0,0 +1,138 @@
+package ws
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// drain reads every frame currently queued for client.
+func drain(client *Client) []string {
+	var frames []string
+	for {
+		select {
+		case frame := <-client.send:
+			frames = append(frames, string(frame))
+		default:
+			return frames
+		}
+	}
+}
+
+func closed(client *Client) bool {
+	select {
+	case <-client.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestOverflowPolicies(t *testing.T) {
+	const queueSize = 2
+	const closeCode = 4008
+
+	tests := []struct {
+		name        string
+		policy      OverflowPolicy
+		frames      int
+		wantQueued  []string
+		wantDropped uint64
+		wantClosed  bool
+		wantCode    int
+	}{
+		{name: "drop_oldest under capacity", policy: DropOldest, frames: 2, wantQueued: []string{"0", "1"}},
+		{name: "drop_oldest full", policy: DropOldest, frames: 5, wantQueued: []string{"3", "4"}, wantDropped: 3},
+		{name: "drop_newest full", policy: DropNewest, frames: 5, wantQueued: []string{"0", "1"}, wantDropped: 3},
+		{name: "disconnect under capacity", policy: Disconnect, frames: 2, wantQueued: []string{"0", "1"}},
+		{name: "disconnect full", policy: Disconnect, frames: 5, wantQueued: []string{"0", "1"}, wantDropped: 1, wantClosed: true, wantCode: closeCode},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			manager := NewManager(Config{SendQueueSize: queueSize, OverflowPolicy: test.policy, OverflowCloseCode: closeCode})
+			client := manager.NewClient(nil, uuid.New())
+
+			for i := 0; i < test.frames; i++ {
+				client.enqueue([]byte(fmt.Sprint(i)))
+			}
+
+			got := drain(client)
+			if fmt.Sprint(got) != fmt.Sprint(test.wantQueued) {
+				t.Errorf("queued = %q, want %q", got, test.wantQueued)
+			}
+			if client.Dropped() != test.wantDropped {
+				t.Errorf("client dropped = %d, want %d", client.Dropped(), test.wantDropped)
+			}
+			if manager.DroppedFrames() != test.wantDropped {
+				t.Errorf("manager dropped = %d, want %d", manager.DroppedFrames(), test.wantDropped)
+			}
+			if closed(client) != test.wantClosed {
+				t.Errorf("closed = %v, want %v", closed(client), test.wantClosed)
+			}
+			if client.closeCode != test.wantCode {
+				t.Errorf("close code = %d, want %d", client.closeCode, test.wantCode)
+			}
+		})
+	}
+}
+
+func TestDroppedFramesSumAcrossClients(t *testing.T) {
+	manager := NewManager(Config{SendQueueSize: 1, OverflowPolicy: DropNewest})
+	first := manager.NewClient(nil, uuid.New())
+	second := manager.NewClient(nil, uuid.New())
+
+	for i := 0; i < 3; i++ {
+		first.enqueue([]byte("frame"))
+	}
+	for i := 0; i < 2; i++ {
+		second.enqueue([]byte("frame"))
+	}
+
+	if first.Dropped() != 2 || second.Dropped() != 1 {
+		t.Errorf("dropped = %d and %d, want 2 and 1", first.Dropped(), second.Dropped())
+	}
+	if manager.DroppedFrames() != 3 {
+		t.Errorf("manager dropped = %d, want 3", manager.DroppedFrames())
+	}
+}
+
+func TestEnqueueAfterClose(t *testing.T) {
+	manager := NewManager(Config{SendQueueSize: 4, OverflowPolicy: DropOldest})
+	client := manager.NewClient(nil, uuid.New())
+	client.close()
+
+	if client.enqueue([]byte("late")) {
+		t.Error("enqueue succeeded on a closed client")
+	}
+	if got := drain(client); len(got) != 0 {
+		t.Errorf("queued = %q, want nothing", got)
+	}
+	if client.Dropped() != 0 {
+		t.Errorf("dropped = %d, want 0", client.Dropped())
+	}
+}
+
+func TestParseOverflowPolicy(t *testing.T) {
+	tests := []struct {
+		value  string
+		want   OverflowPolicy
+		wantOK bool
+	}{
+		{value: "drop_oldest", want: DropOldest, wantOK: true},
+		{value: " Drop_Newest ", want: DropNewest, wantOK: true},
+		{value: "disconnect", want: Disconnect, wantOK: true},
+		{value: "block", want: DropOldest, wantOK: false},
+		{value: "", want: DropOldest, wantOK: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			got, ok := parseOverflowPolicy(test.value)
+			if got != test.want || ok != test.wantOK {
+				t.Errorf("parseOverflowPolicy(%q) = %v, %v; want %v, %v", test.value, got, ok, test.want, test.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package ws
+
+import (
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/websocket/v2"
+)
+
+// OverflowPolicy decides what happens when a client's send queue is full.
+type OverflowPolicy int
+
+const (
+	// DropOldest discards the oldest queued frame to make room for the new one.
+	DropOldest OverflowPolicy = iota
+	// DropNewest discards the frame that did not fit.
+	DropNewest
+	// Disconnect closes the connection with Config.OverflowCloseCode.
+	Disconnect
+)
+
+func (policy OverflowPolicy) String() string {
+	switch policy {
+	case DropNewest:
+		return "drop_newest"
+	case Disconnect:
+		return "disconnect"
+	default:
+		return "drop_oldest"
+	}
+}
+
+func parseOverflowPolicy(value string) (OverflowPolicy, bool) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "drop_oldest":
+		return DropOldest, true
+	case "drop_newest":
+		return DropNewest, true
+	case "disconnect":
+		return Disconnect, true
+	}
+	return DropOldest, false
+}
+
+type Config struct {
+	Shards            int
+	SendQueueSize     int
+	OverflowPolicy    OverflowPolicy
+	OverflowCloseCode int
+	// How long without frames from any of a user's sockets before they show as away
+	IdleAfter time.Duration
+	// Key for signed global announcements; global delivery is off when empty
+	BroadcastSecret string
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Shards:            runtime.NumCPU(),
+		SendQueueSize:     256,
+		OverflowPolicy:    DropOldest,
+		OverflowCloseCode: websocket.CloseTryAgainLater,
+		IdleAfter:         5 * time.Minute,
+	}
+}
+
+// ConfigFromEnv reads WS_SHARDS, WS_SEND_QUEUE_SIZE, WS_OVERFLOW_POLICY,
+// WS_OVERFLOW_CLOSE_CODE, WS_IDLE_AFTER and WS_BROADCAST_SECRET, falling
+// back to DefaultConfig for anything unset.
+func ConfigFromEnv() Config {
+	config := DefaultConfig()
+
+	if shards, err := strconv.Atoi(os.Getenv("WS_SHARDS")); err == nil && shards > 0 {
+		config.Shards = shards
+	}
+
+	if size, err := strconv.Atoi(os.Getenv("WS_SEND_QUEUE_SIZE")); err == nil && size > 0 {
+		config.SendQueueSize = size
+	}
+
+	if policy, ok := parseOverflowPolicy(os.Getenv("WS_OVERFLOW_POLICY")); ok {
+		config.OverflowPolicy = policy
+	}
+
+	if code, err := strconv.Atoi(os.Getenv("WS_OVERFLOW_CLOSE_CODE")); err == nil && code > 0 {
+		config.OverflowCloseCode = code
+	}
+
+	if idleAfter, err := time.ParseDuration(os.Getenv("WS_IDLE_AFTER")); err == nil && idleAfter > 0 {
+		config.IdleAfter = idleAfter
+	}
+
+	config.BroadcastSecret = os.Getenv("WS_BROADCAST_SECRET")
+
+	return config
+}
@@ -2,8 +2,7 @@ package ws
 
 import (
 	"encoding/json"
-	"huddle-ws-server/database"
-	"huddle-ws-server/models"
+	"huddle-ws-server/cache"
 	"huddle-ws-server/types"
 	"log"
 	"time"
@@ -17,51 +16,36 @@ func WebsocketHandler(c *websocket.Conn) {
 	log.Printf("New WebSocket connection from user: %s", userID)
 
 	// Create new client
-	client := &Client{
-		Connection:     c,
-		UserId:         userID,
-		Channels:       make(map[uuid.UUID]bool),
-		DirectMessages: make(map[uuid.UUID]bool),
-	}
+	client := WsManager.NewClient(c, userID)
+	go client.writePump()
 
 	// Register client
-	WsManager.register <- client
+	WsManager.register(client)
+	trackConnect(client)
 
-	// Subscribe to user's channels and conversations
+	// Subscribe to user's teams, channels and conversations
+	subscribeToUserTeams(client)
 	subscribeToUserChannels(client)
 	subscribeToUserConversations(client)
 
+	// Tell the client its connection ID so it can tag the events it originates
+	client.Send(types.Message{
+		Type: "connected",
+		Data: types.ConnectedPayload{ConnectionId: client.ConnectionId},
+	})
+
 	// Set up a ping handler to detect disconnections
 	c.SetPingHandler(func(string) error {
 		return c.WriteControl(websocket.PongMessage, []byte{}, time.Now().Add(time.Second))
 	})
 
-	// Create a done channel to signal goroutine cleanup
-	done := make(chan struct{})
-	defer close(done)
-
-	// Start a goroutine to handle ping/pong
-	go func() {
-		ticker := time.NewTicker(30 * time.Second)
-		defer ticker.Stop()
-
-		for {
-			select {
-			case <-ticker.C:
-				if err := c.WriteControl(websocket.PingMessage, []byte{}, time.Now().Add(time.Second)); err != nil {
-					log.Printf("ping error: %v", err)
-					WsManager.unregister <- client
-					return
-				}
-			case <-done:
-				return
-			}
-		}
-	}()
-
+	// The connection is released once this handler returns, so wait for the writer to stop first
 	defer func() {
 		log.Printf("WebSocket connection closed for user: %s", userID)
-		WsManager.unregister <- client
+		WsManager.unregister(client)
+		typing.stopConnection(client)
+		trackDisconnect(client)
+		<-client.writerDone
 	}()
 
 	for {
@@ -74,16 +58,18 @@ func WebsocketHandler(c *websocket.Conn) {
 		}
 
 		if messageType == websocket.TextMessage {
+			WsManager.recordActivity(client)
+
 			var payload interface{}
 			if err := json.Unmarshal(msg, &payload); err != nil {
 				continue
 			}
-			handleIncomingMessage(payload, userID)
+			handleIncomingMessage(client, payload)
 		}
 	}
 }
 
-func handleIncomingMessage(payload interface{}, userID uuid.UUID) {
+func handleIncomingMessage(client *Client, payload interface{}) {
 	// First, determine the type of message
 	var messageType struct {
 		Type string `json:"type"`
@@ -100,50 +86,129 @@ func handleIncomingMessage(payload interface{}, userID uuid.UUID) {
 		if err := json.Unmarshal(payloadBytes, &typingPayload); err != nil {
 			return
 		}
+		handleTyping(client, typingPayload)
+
+	case "subscribe", "unsubscribe":
+		var subscriptionPayload types.SubscriptionPayload
+		if err := json.Unmarshal(payloadBytes, &subscriptionPayload); err != nil {
+			sendError(client, "", types.ErrorCodeInvalidRequest, "malformed subscription frame")
+			return
+		}
+		handleSubscription(client, subscriptionPayload)
+
+	case "resume":
+		var resumePayload types.ResumePayload
+		if err := json.Unmarshal(payloadBytes, &resumePayload); err != nil {
+			sendError(client, "", types.ErrorCodeInvalidRequest, "malformed resume frame")
+			return
+		}
+		handleResume(client, resumePayload)
+
+	case "set_status":
+		var statusPayload types.SetStatusPayload
+		if err := json.Unmarshal(payloadBytes, &statusPayload); err != nil {
+			sendError(client, "", types.ErrorCodeInvalidRequest, "malformed set_status frame")
+			return
+		}
+		handleSetStatus(client, statusPayload)
+
+	case "get_presence":
+		var presencePayload types.GetPresencePayload
+		if err := json.Unmarshal(payloadBytes, &presencePayload); err != nil {
+			sendError(client, "", types.ErrorCodeInvalidRequest, "malformed get_presence frame")
+			return
+		}
+		handleGetPresence(client, presencePayload)
 
-		var user models.User
-		if err := database.DB.Where("id = ?", userID).First(&user).Error; err != nil {
+	case "send_message":
+		var sendPayload types.SendMessagePayload
+		if err := json.Unmarshal(payloadBytes, &sendPayload); err != nil {
+			sendError(client, "", types.ErrorCodeInvalidRequest, "malformed send_message frame")
 			return
 		}
+		handleSendMessage(client, sendPayload)
 
-		typingPayload.UserAvatar = &user.ProfileImage
-		typingPayload.UserDisplayName = &user.DisplayName
+	case "edit_message":
+		var editPayload types.EditMessagePayload
+		if err := json.Unmarshal(payloadBytes, &editPayload); err != nil {
+			sendError(client, "", types.ErrorCodeInvalidRequest, "malformed edit_message frame")
+			return
+		}
+		handleEditMessage(client, editPayload)
+
+	case "delete_message":
+		var deletePayload types.DeleteMessagePayload
+		if err := json.Unmarshal(payloadBytes, &deletePayload); err != nil {
+			sendError(client, "", types.ErrorCodeInvalidRequest, "malformed delete_message frame")
+			return
+		}
+		handleDeleteMessage(client, deletePayload)
+
+	case "add_reaction", "remove_reaction":
+		var reactionPayload types.ReactionPayload
+		if err := json.Unmarshal(payloadBytes, &reactionPayload); err != nil {
+			sendError(client, "", types.ErrorCodeInvalidRequest, "malformed reaction frame")
+			return
+		}
+		handleReaction(client, reactionPayload)
+
+	case "mark_read":
+		var readPayload types.MarkReadPayload
+		if err := json.Unmarshal(payloadBytes, &readPayload); err != nil {
+			sendError(client, "", types.ErrorCodeInvalidRequest, "malformed mark_read frame")
+			return
+		}
+		handleMarkRead(client, readPayload)
+
+	case "delivery_ack":
+		var deliveryPayload types.DeliveryAckPayload
+		if err := json.Unmarshal(payloadBytes, &deliveryPayload); err != nil {
+			return
+		}
+		handleDeliveryAck(client, deliveryPayload)
+	}
+}
 
-		var message types.Message
+func sendError(client *Client, requestID string, code string, message string) {
+	client.Send(types.Message{
+		Type: "error",
+		Data: types.ErrorPayload{
+			Code:      code,
+			Message:   message,
+			RequestId: requestID,
+		},
+	})
+}
 
-		message.ChannelID = typingPayload.ChannelId
-		message.ConversationID = typingPayload.ConversationId
-		message.Message.SenderID = userID.String()
-		message.Type = typingPayload.Type
-		message.Data = typingPayload
+func subscribeToUserTeams(client *Client) {
+	teamIDs, err := cache.TeamIDs(client.UserId)
+	if err != nil {
+		return
+	}
 
-		WsManager.BroadcastMessage(message)
+	for _, teamID := range teamIDs {
+		WsManager.SubscribeToTeam(client, teamID)
 	}
 }
 
 func subscribeToUserChannels(client *Client) {
-	var channels []models.TeamChannel
-	if err := database.DB.
-		Joins("JOIN team_members ON team_members.team_id = team_channels.team_id").
-		Where("team_members.user_id = ?", client.UserId).
-		Find(&channels).Error; err != nil {
+	channelIDs, err := cache.ChannelIDs(client.UserId)
+	if err != nil {
 		return
 	}
 
-	for _, channel := range channels {
-		WsManager.SubscribeToChannel(client, channel.ID)
+	for _, channelID := range channelIDs {
+		WsManager.SubscribeToChannel(client, channelID)
 	}
 }
 
 func subscribeToUserConversations(client *Client) {
-	var conversations []models.Conversation
-	if err := database.DB.
-		Where("user1_id = ? OR user2_id = ?", client.UserId, client.UserId).
-		Find(&conversations).Error; err != nil {
+	conversationIDs, err := cache.ConversationIDs(client.UserId)
+	if err != nil {
 		return
 	}
 
-	for _, conv := range conversations {
-		WsManager.SubscribeToConversation(client, conv.ID)
+	for _, conversationID := range conversationIDs {
+		WsManager.SubscribeToConversation(client, conversationID)
 	}
 }
@@ -0,0 +1,85 @@
+package ws
+
+import (
+	"huddle-ws-server/database"
+	"huddle-ws-server/rd"
+	"huddle-ws-server/types"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const maxDeliveryAcks = 100
+
+// handleDeliveryAck records messages that reached the client's device. Acks
+// are fire-and-forget, so nothing is sent back to the acking client.
+func handleDeliveryAck(client *Client, payload types.DeliveryAckPayload) {
+	if len(payload.MessageIds) == 0 {
+		return
+	}
+	if len(payload.MessageIds) > maxDeliveryAcks {
+		payload.MessageIds = payload.MessageIds[:maxDeliveryAcks]
+	}
+
+	// Only messages from others in conversations the user can read count,
+	// and only direct messages show delivery state to their sender
+	var messages []struct {
+		ID             uuid.UUID
+		SenderID       uuid.UUID
+		ConversationID uuid.UUID
+	}
+	err := database.DB.Raw(`
+		SELECT messages.id, messages.sender_id, messages.conversation_id
+		FROM messages
+		JOIN conversations ON conversations.id = messages.conversation_id
+		WHERE messages.id IN ? AND messages.deleted_at IS NULL
+			AND messages.sender_id <> ?
+			AND (conversations.user1_id = ? OR conversations.user2_id = ?)
+	`, payload.MessageIds, client.UserId, client.UserId, client.UserId).Scan(&messages).Error
+	if err != nil {
+		log.Printf("failed to load acked messages for user %s: %v", client.UserId, err)
+		return
+	}
+	if len(messages) == 0 {
+		return
+	}
+
+	// Without Redis every ack is passed on; the client dedupes
+	newly := make([]bool, len(messages))
+	for i := range newly {
+		newly[i] = true
+	}
+	if rd.Available() {
+		messageIDs := make([]string, len(messages))
+		for i, message := range messages {
+			messageIDs[i] = message.ID.String()
+		}
+		if newly, err = rd.MarkDelivered(messageIDs, client.UserId.String()); err != nil {
+			log.Printf("failed to record deliveries for user %s: %v", client.UserId, err)
+			return
+		}
+	}
+
+	now := time.Now()
+	for i, message := range messages {
+		if !newly[i] {
+			continue
+		}
+
+		conversationID := message.ConversationID
+		err := WsManager.SendToUser(message.SenderID, types.Message{
+			Type:           "delivered",
+			ConversationID: &conversationID,
+			Data: types.DeliveredPayload{
+				MessageId:      message.ID,
+				ConversationId: message.ConversationID,
+				UserId:         client.UserId,
+				DeliveredAt:    now,
+			},
+		})
+		if err != nil {
+			log.Printf("failed to publish delivery receipt: %v", err)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package ws
+
+import (
+	"errors"
+	"huddle-ws-server/database"
+	"huddle-ws-server/models"
+	"huddle-ws-server/types"
+	"log"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+func handleEditMessage(client *Client, payload types.EditMessagePayload) {
+	content, ok := validContent(client, payload.RequestId, payload.Content)
+	if !ok {
+		return
+	}
+
+	message, channelID, ok := loadOwnMessage(client, payload.MessageId, payload.RequestId)
+	if !ok {
+		return
+	}
+
+	message.Content = content
+	message.IsEdited = true
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&message).Updates(map[string]interface{}{
+			"content":   message.Content,
+			"is_edited": true,
+		}).Error; err != nil {
+			return err
+		}
+		return refreshPreview(tx, message.ConversationID)
+	})
+	if err != nil {
+		log.Printf("failed to edit message %s: %v", message.ID, err)
+		sendError(client, payload.RequestId, types.ErrorCodeInternal, "could not edit message")
+		return
+	}
+
+	response := messageResponse(message, channelID)
+	publishMessageEvent(messageUpdateEvent("message_updated", message, channelID, response, client))
+
+	client.Send(types.Message{
+		Type:    "message_edited",
+		Message: response,
+		Data:    types.AckPayload{RequestId: payload.RequestId},
+	})
+}
+
+func handleDeleteMessage(client *Client, payload types.DeleteMessagePayload) {
+	message, channelID, ok := loadOwnMessage(client, payload.MessageId, payload.RequestId)
+	if !ok {
+		return
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		// Soft delete through DeletedAt
+		if err := tx.Delete(&message).Error; err != nil {
+			return err
+		}
+		return refreshPreview(tx, message.ConversationID)
+	})
+	if err != nil {
+		log.Printf("failed to delete message %s: %v", message.ID, err)
+		sendError(client, payload.RequestId, types.ErrorCodeInternal, "could not delete message")
+		return
+	}
+
+	// Deleted content must not travel any further
+	response := types.MessageResponse{
+		ID:             message.ID.String(),
+		SenderID:       message.SenderID.String(),
+		ConversationID: message.ConversationID.String(),
+	}
+	if channelID != nil {
+		response.ChannelID = channelID.String()
+	}
+	message.Content = ""
+	publishMessageEvent(messageUpdateEvent("message_deleted", message, channelID, response, client))
+
+	client.Send(types.Message{
+		Type:    "message_removed",
+		Message: response,
+		Data:    types.AckPayload{RequestId: payload.RequestId},
+	})
+}
+
+// loadOwnMessage fetches a message the client's user wrote and may still change,
+// along with the channel it was posted in, if any. Failures are reported to the client.
+func loadOwnMessage(client *Client, messageID uuid.UUID, requestID string) (models.Message, *uuid.UUID, bool) {
+	var message models.Message
+	if err := database.DB.Where("id = ?", messageID).First(&message).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			sendError(client, requestID, types.ErrorCodeInvalidRequest, "message not found")
+		} else {
+			log.Printf("failed to load message %s: %v", messageID, err)
+			sendError(client, requestID, types.ErrorCodeInternal, "could not load message")
+		}
+		return message, nil, false
+	}
+
+	if message.SenderID != client.UserId {
+		sendError(client, requestID, types.ErrorCodeForbidden, "you can only change your own messages")
+		return message, nil, false
+	}
+
+	var conversation models.Conversation
+	if err := database.DB.Where("id = ?", message.ConversationID).First(&conversation).Error; err != nil {
+		log.Printf("failed to load conversation %s: %v", message.ConversationID, err)
+		sendError(client, requestID, types.ErrorCodeInternal, "could not load message")
+		return message, nil, false
+	}
+
+	if conversation.ChannelID == uuid.Nil {
+		return message, nil, true
+	}
+
+	// Leaving the team or archiving the channel freezes its history
+	channelID := conversation.ChannelID
+	access, err := loadChannelAccess(client.UserId, channelID)
+	if err != nil {
+		log.Printf("channel check failed for user %s: %v", client.UserId, err)
+		sendError(client, requestID, types.ErrorCodeInternal, "could not verify membership")
+		return message, nil, false
+	}
+	if access == nil || access.IsArchived {
+		sendError(client, requestID, types.ErrorCodeForbidden, "this channel can no longer be changed")
+		return message, nil, false
+	}
+
+	return message, &channelID, true
+}
+
+// refreshPreview points the conversation's preview at its newest remaining message.
+func refreshPreview(tx *gorm.DB, conversationID uuid.UUID) error {
+	var latest []models.Message
+	if err := tx.Where("conversation_id = ?", conversationID).
+		Order("created_at DESC").
+		Limit(1).
+		Find(&latest).Error; err != nil {
+		return err
+	}
+
+	updates := map[string]interface{}{
+		"last_message_text": "",
+		"has_messages":      false,
+	}
+	if len(latest) > 0 {
+		updates = map[string]interface{}{
+			"last_message_at":   latest[0].CreatedAt,
+			"last_message_text": previewOf(latest[0].Content),
+			"last_message_by":   latest[0].SenderID,
+			"has_messages":      true,
+		}
+	}
+
+	return tx.Model(&models.Conversation{}).Where("id = ?", conversationID).Updates(updates).Error
+}
+
+// messageUpdateEvent addresses an edit or delete to the message's channel or conversation.
+func messageUpdateEvent(eventType string, message models.Message, channelID *uuid.UUID, response types.MessageResponse, client *Client) types.Message {
+	event := types.Message{
+		Type:               eventType,
+		Message:            response,
+		OriginConnectionID: &client.ConnectionId,
+		Data: types.MessageUpdateData{
+			MessageID: message.ID.String(),
+			Content:   message.Content,
+			IsEdited:  message.IsEdited,
+			UserId:    client.UserId.String(),
+			Status:    eventType,
+		},
+	}
+
+	if channelID != nil {
+		event.ChannelID = channelID
+	} else {
+		conversationID := message.ConversationID
+		event.ConversationID = &conversationID
+	}
+
+	return event
+}
@@ -0,0 +1,93 @@
+package ws
+
+import (
+	"huddle-ws-server/types"
+
+	"github.com/google/uuid"
+)
+
+// addClient and removeClient keep clients, userConns and the topic indexes
+// consistent. Only registered clients are indexed, so subscriptions made
+// before registration are picked up here. Callers must hold the shard's write lock.
+func (shard *shard) addClient(client *Client) {
+	shard.clients[client] = true
+	shard.userConns[client.UserId] = append(shard.userConns[client.UserId], client)
+
+	for channelID := range client.Channels {
+		addToIndex(shard.channelSubs, channelID, client)
+	}
+	for conversationID := range client.DirectMessages {
+		addToIndex(shard.conversationSubs, conversationID, client)
+	}
+	for teamID := range client.Teams {
+		addToIndex(shard.teamSubs, teamID, client)
+	}
+}
+
+func (shard *shard) removeClient(client *Client) {
+	delete(shard.clients, client)
+	shard.removeUserConnections(client)
+
+	for channelID := range client.Channels {
+		removeFromIndex(shard.channelSubs, channelID, client)
+	}
+	for conversationID := range client.DirectMessages {
+		removeFromIndex(shard.conversationSubs, conversationID, client)
+	}
+	for teamID := range client.Teams {
+		removeFromIndex(shard.teamSubs, teamID, client)
+	}
+}
+
+func (shard *shard) removeUserConnections(client *Client) {
+	if connections, ok := shard.userConns[client.UserId]; ok {
+		newConnections := make([]*Client, 0)
+		for _, connection := range connections {
+			if connection != client {
+				newConnections = append(newConnections, connection)
+			}
+		}
+		if len(newConnections) == 0 {
+			delete(shard.userConns, client.UserId)
+		} else {
+			shard.userConns[client.UserId] = newConnections
+		}
+	}
+}
+
+func addToIndex(index map[uuid.UUID]map[*Client]bool, topicID uuid.UUID, client *Client) {
+	subscribers, ok := index[topicID]
+	if !ok {
+		subscribers = make(map[*Client]bool)
+		index[topicID] = subscribers
+	}
+	subscribers[client] = true
+}
+
+func removeFromIndex(index map[uuid.UUID]map[*Client]bool, topicID uuid.UUID, client *Client) {
+	if subscribers, ok := index[topicID]; ok {
+		delete(subscribers, client)
+		if len(subscribers) == 0 {
+			delete(index, topicID)
+		}
+	}
+}
+
+// forEachSubscriber calls fn for every client subscribed to the message's topic,
+// walking the smallest index that applies. Callers must hold the shard's read lock.
+func (shard *shard) forEachSubscriber(msg types.Message, fn func(client *Client)) {
+	switch {
+	case msg.ChannelID != nil:
+		for client := range shard.channelSubs[*msg.ChannelID] {
+			// Messages carrying both IDs must match both subscriptions
+			if msg.ConversationID != nil && !client.DirectMessages[*msg.ConversationID] {
+				continue
+			}
+			fn(client)
+		}
+	case msg.ConversationID != nil:
+		for client := range shard.conversationSubs[*msg.ConversationID] {
+			fn(client)
+		}
+	}
+}
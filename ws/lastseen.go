@@ -0,0 +1,96 @@
+package ws
+
+import (
+	"huddle-ws-server/database"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	lastSeenFlushInterval = 5 * time.Second
+	lastSeenMaxBatch      = 500
+)
+
+// lastSeenWriter batches users.last_active updates so a disconnect storm
+// turns into a handful of UPDATE statements.
+type lastSeenWriter struct {
+	mutex   sync.Mutex
+	pending map[uuid.UUID]time.Time
+}
+
+var lastSeen = &lastSeenWriter{pending: make(map[uuid.UUID]time.Time)}
+
+func (writer *lastSeenWriter) record(userID uuid.UUID, at time.Time) {
+	writer.mutex.Lock()
+	if at.After(writer.pending[userID]) {
+		writer.pending[userID] = at
+	}
+	// Only crossing the threshold triggers an early flush, so a backlog kept
+	// through a database outage does not start one per disconnect
+	full := len(writer.pending) == lastSeenMaxBatch
+	writer.mutex.Unlock()
+
+	if full {
+		go writer.flush()
+	}
+}
+
+// get returns a timestamp still waiting to be written, if any.
+func (writer *lastSeenWriter) get(userID uuid.UUID) time.Time {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	return writer.pending[userID]
+}
+
+func (writer *lastSeenWriter) run() {
+	ticker := time.NewTicker(lastSeenFlushInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		writer.flush()
+	}
+}
+
+func (writer *lastSeenWriter) flush() {
+	writer.mutex.Lock()
+	pending := writer.pending
+	writer.pending = make(map[uuid.UUID]time.Time)
+	writer.mutex.Unlock()
+
+	if len(pending) == 0 {
+		return
+	}
+
+	rows := make([]string, 0, len(pending))
+	args := make([]interface{}, 0, len(pending)*2)
+	for userID, at := range pending {
+		rows = append(rows, "(?::uuid, ?::timestamptz)")
+		args = append(args, userID, at)
+	}
+
+	query := `UPDATE users SET last_active = batch.last_active
+		FROM (VALUES ` + strings.Join(rows, ", ") + `) AS batch(id, last_active)
+		WHERE users.id = batch.id AND (users.last_active IS NULL OR users.last_active < batch.last_active)`
+
+	if err := database.DB.Exec(query, args...).Error; err != nil {
+		log.Printf("failed to persist last seen for %d users, retrying next flush: %v", len(pending), err)
+		writer.restore(pending)
+	}
+}
+
+// restore puts back timestamps a failed flush could not write, unless a
+// newer one was recorded in the meantime.
+func (writer *lastSeenWriter) restore(pending map[uuid.UUID]time.Time) {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+
+	for userID, at := range pending {
+		if at.After(writer.pending[userID]) {
+			writer.pending[userID] = at
+		}
+	}
+}
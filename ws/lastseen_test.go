@@ -0,0 +1,42 @@
+package ws
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestLastSeenRestoreKeepsNewest(t *testing.T) {
+	now := time.Now()
+	restored := uuid.New()
+	recordedSince := uuid.New()
+	staleRestore := uuid.New()
+
+	writer := &lastSeenWriter{pending: make(map[uuid.UUID]time.Time)}
+	writer.record(recordedSince, now)
+	writer.record(staleRestore, now)
+
+	writer.restore(map[uuid.UUID]time.Time{
+		restored:      now.Add(-time.Minute),
+		recordedSince: now.Add(-time.Minute),
+		staleRestore:  now.Add(time.Minute),
+	})
+
+	tests := []struct {
+		name   string
+		userID uuid.UUID
+		want   time.Time
+	}{
+		{name: "failed write is kept", userID: restored, want: now.Add(-time.Minute)},
+		{name: "newer record wins over restore", userID: recordedSince, want: now},
+		{name: "newer restore wins over record", userID: staleRestore, want: now.Add(time.Minute)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := writer.get(test.userID); !got.Equal(test.want) {
+				t.Errorf("pending = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package ws
+
+import (
+	"huddle-ws-server/cache"
+	"huddle-ws-server/database"
+	"huddle-ws-server/models"
+	"huddle-ws-server/types"
+	"log"
+
+	"github.com/google/uuid"
+)
+
+// clientsForUser returns a snapshot of the user's connections on this node.
+func (manager *Manager) clientsForUser(userID uuid.UUID) []*Client {
+	shard := manager.shardFor(userID)
+	shard.mutex.RLock()
+	defer shard.mutex.RUnlock()
+
+	return append([]*Client(nil), shard.userConns[userID]...)
+}
+
+// ApplyMembershipEvent updates the subscriptions of the user's local
+// connections and tells each of them what changed.
+func (manager *Manager) ApplyMembershipEvent(event types.MembershipEvent) {
+	if event.Action == "role_changed" {
+		if event.TeamId != nil {
+			manager.resyncTeamChannels(*event.TeamId, []uuid.UUID{event.UserId}, nil)
+		}
+		return
+	}
+
+	add := event.Action == "add"
+	if !add && event.Action != "remove" {
+		return
+	}
+
+	clients := manager.clientsForUser(event.UserId)
+	if len(clients) == 0 {
+		return
+	}
+
+	// Joining only opens the channels the user's role can see, whether they
+	// were named or come with the team; leaving closes them all
+	channelIDs := append([]uuid.UUID(nil), event.ChannelIds...)
+	if event.TeamId != nil {
+		teamChannelIDs, err := teamChannelIDs(*event.TeamId)
+		if err != nil {
+			log.Printf("failed to load channels for team %s: %v", *event.TeamId, err)
+			return
+		}
+		channelIDs = append(channelIDs, teamChannelIDs...)
+	}
+	if add && len(channelIDs) > 0 {
+		visible, err := cache.VisibleChannels([]uuid.UUID{event.UserId}, nil, channelIDs)
+		if err != nil {
+			log.Printf("failed to load visible channels for user %s: %v", event.UserId, err)
+			return
+		}
+		candidates := channelIDs
+		channelIDs = nil
+		for _, channelID := range candidates {
+			if visible[event.UserId][channelID] {
+				channelIDs = append(channelIDs, channelID)
+				// A channel both named and in the team is only added once
+				delete(visible[event.UserId], channelID)
+			}
+		}
+	}
+
+	if event.TeamId == nil && len(channelIDs) == 0 && len(event.ConversationIds) == 0 {
+		return
+	}
+
+	for _, client := range clients {
+		if event.TeamId != nil {
+			if add {
+				manager.SubscribeToTeam(client, *event.TeamId)
+			} else {
+				manager.UnsubscribeFromTeam(client, *event.TeamId)
+			}
+		}
+		for _, channelID := range channelIDs {
+			if add {
+				manager.SubscribeToChannel(client, channelID)
+			} else {
+				manager.UnsubscribeFromChannel(client, channelID)
+			}
+		}
+		for _, conversationID := range event.ConversationIds {
+			if add {
+				manager.SubscribeToConversation(client, conversationID)
+			} else {
+				manager.UnsubscribeFromConversation(client, conversationID)
+			}
+		}
+
+		client.Send(types.Message{
+			Type: "subscription_changed",
+			Data: types.SubscriptionChange{
+				Action:          event.Action,
+				TeamId:          event.TeamId,
+				ChannelIds:      channelIDs,
+				ConversationIds: event.ConversationIds,
+			},
+		})
+	}
+}
+
+// ApplyChannelEvent re-checks the channel for every local member of its team,
+// so archiving or narrowing AllowedRoles takes effect on open sockets.
+func (manager *Manager) ApplyChannelEvent(event types.ChannelEvent) {
+	userIDs := manager.localTeamMembers(event.TeamId)
+	if len(userIDs) == 0 {
+		return
+	}
+	manager.resyncTeamChannels(event.TeamId, userIDs, &event.ChannelId)
+}
+
+// resyncTeamChannels brings the users' local channel subscriptions in the
+// team, or just channelID, in line with what they may see now.
+func (manager *Manager) resyncTeamChannels(teamID uuid.UUID, userIDs []uuid.UUID, channelID *uuid.UUID) {
+	scope := []uuid.UUID{}
+	if channelID != nil {
+		scope = append(scope, *channelID)
+	} else {
+		var err error
+		if scope, err = teamChannelIDs(teamID); err != nil {
+			log.Printf("failed to load channels for team %s: %v", teamID, err)
+			return
+		}
+	}
+
+	var narrowed []uuid.UUID
+	if channelID != nil {
+		narrowed = scope
+	}
+	visible, err := cache.VisibleChannels(userIDs, &teamID, narrowed)
+	if err != nil {
+		log.Printf("failed to load visible channels for team %s: %v", teamID, err)
+		return
+	}
+
+	for _, userID := range userIDs {
+		cache.InvalidateMemberships(userID)
+
+		for _, client := range manager.clientsForUser(userID) {
+			var added, removed []uuid.UUID
+			for _, scopedChannelID := range scope {
+				subscribed := manager.isSubscribed(client, &scopedChannelID, nil)
+				switch want := visible[userID][scopedChannelID]; {
+				case want && !subscribed:
+					manager.SubscribeToChannel(client, scopedChannelID)
+					added = append(added, scopedChannelID)
+				case !want && subscribed:
+					manager.UnsubscribeFromChannel(client, scopedChannelID)
+					removed = append(removed, scopedChannelID)
+				}
+			}
+
+			if len(added) > 0 {
+				client.Send(types.Message{
+					Type: "subscription_changed",
+					Data: types.SubscriptionChange{Action: "add", TeamId: &teamID, ChannelIds: added},
+				})
+			}
+			if len(removed) > 0 {
+				client.Send(types.Message{
+					Type: "subscription_changed",
+					Data: types.SubscriptionChange{Action: "remove", TeamId: &teamID, ChannelIds: removed},
+				})
+			}
+		}
+	}
+}
+
+// localTeamMembers lists the distinct users connected to this node who belong to the team.
+func (manager *Manager) localTeamMembers(teamID uuid.UUID) []uuid.UUID {
+	seen := make(map[uuid.UUID]bool)
+	userIDs := make([]uuid.UUID, 0)
+	for _, shard := range manager.shards {
+		shard.mutex.RLock()
+		for client := range shard.teamSubs[teamID] {
+			if !seen[client.UserId] {
+				seen[client.UserId] = true
+				userIDs = append(userIDs, client.UserId)
+			}
+		}
+		shard.mutex.RUnlock()
+	}
+	return userIDs
+}
+
+func teamChannelIDs(teamID uuid.UUID) ([]uuid.UUID, error) {
+	var channelIDs []uuid.UUID
+	err := database.DB.Model(&models.TeamChannel{}).
+		Where("team_id = ?", teamID).
+		Pluck("id", &channelIDs).Error
+	return channelIDs, err
+}
@@ -0,0 +1,198 @@
+package ws
+
+import (
+	"encoding/json"
+	"huddle-ws-server/broker"
+	"huddle-ws-server/cache"
+	"huddle-ws-server/database"
+	"huddle-ws-server/models"
+	"huddle-ws-server/types"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	maxMessageLength = 4000
+	// Conversation.LastMessageText only feeds list previews
+	maxPreviewLength = 100
+)
+
+func handleSendMessage(client *Client, payload types.SendMessagePayload) {
+	if (payload.ChannelId == nil) == (payload.ConversationId == nil) {
+		sendError(client, payload.RequestId, types.ErrorCodeInvalidRequest, "exactly one of channelId or conversationId is required")
+		return
+	}
+
+	content, ok := validContent(client, payload.RequestId, payload.Content)
+	if !ok {
+		return
+	}
+
+	contentType := payload.ContentType
+	if contentType == "" {
+		contentType = "text"
+	}
+
+	// Channel messages are stored against the channel's backing conversation
+	var conversationID uuid.UUID
+	if payload.ChannelId != nil {
+		access, err := loadChannelAccess(client.UserId, *payload.ChannelId)
+		if err != nil {
+			log.Printf("channel check failed for user %s: %v", client.UserId, err)
+			sendError(client, payload.RequestId, types.ErrorCodeInternal, "could not verify membership")
+			return
+		}
+		if access == nil {
+			sendError(client, payload.RequestId, types.ErrorCodeForbidden, "not a member of this channel")
+			return
+		}
+		if !access.canPost() {
+			sendError(client, payload.RequestId, types.ErrorCodeForbidden, "you cannot post in this channel")
+			return
+		}
+		conversationID = access.ConversationID
+	} else {
+		allowed, err := canAccessConversation(client.UserId, *payload.ConversationId)
+		if err != nil {
+			log.Printf("conversation check failed for user %s: %v", client.UserId, err)
+			sendError(client, payload.RequestId, types.ErrorCodeInternal, "could not verify membership")
+			return
+		}
+		if !allowed {
+			sendError(client, payload.RequestId, types.ErrorCodeForbidden, "not a member of this conversation")
+			return
+		}
+		conversationID = *payload.ConversationId
+	}
+
+	if payload.ReplyToMessageId != nil {
+		var count int64
+		if err := database.DB.Model(&models.Message{}).
+			Where("id = ? AND conversation_id = ?", *payload.ReplyToMessageId, conversationID).
+			Count(&count).Error; err != nil || count == 0 {
+			sendError(client, payload.RequestId, types.ErrorCodeInvalidRequest, "replyToMessageId is not in this conversation")
+			return
+		}
+	}
+
+	message := models.Message{
+		ConversationID:   conversationID,
+		SenderID:         client.UserId,
+		Content:          content,
+		ContentType:      contentType,
+		ReplyToMessageID: payload.ReplyToMessageId,
+	}
+	if err := storeMessage(&message); err != nil {
+		log.Printf("failed to store message from user %s: %v", client.UserId, err)
+		sendError(client, payload.RequestId, types.ErrorCodeInternal, "could not send message")
+		return
+	}
+
+	event := types.Message{
+		Type:               "new_message",
+		ChannelID:          payload.ChannelId,
+		ConversationID:     payload.ConversationId,
+		Message:            messageResponse(message, payload.ChannelId),
+		OriginConnectionID: &client.ConnectionId,
+		ClientMessageID:    payload.ClientMessageId,
+	}
+	publishMessageEvent(event)
+
+	client.Send(types.Message{
+		Type:            "message_sent",
+		ChannelID:       payload.ChannelId,
+		ConversationID:  payload.ConversationId,
+		ClientMessageID: payload.ClientMessageId,
+		Data: types.MessageSentPayload{
+			MessageId:       message.ID.String(),
+			ClientMessageId: payload.ClientMessageId,
+			CreatedAt:       message.CreatedAt.Format(time.RFC3339Nano),
+			RequestId:       payload.RequestId,
+		},
+	})
+}
+
+// validContent trims the content and reports an error to the client when it is empty or too long.
+func validContent(client *Client, requestID string, content string) (string, bool) {
+	content = strings.TrimSpace(content)
+	if content == "" {
+		sendError(client, requestID, types.ErrorCodeInvalidRequest, "content is required")
+		return "", false
+	}
+	if utf8.RuneCountInString(content) > maxMessageLength {
+		sendError(client, requestID, types.ErrorCodeInvalidRequest, "message is too long")
+		return "", false
+	}
+	return content, true
+}
+
+func previewOf(content string) string {
+	if utf8.RuneCountInString(content) > maxPreviewLength {
+		return string([]rune(content)[:maxPreviewLength])
+	}
+	return content
+}
+
+// storeMessage inserts the message and moves its conversation's preview forward.
+func storeMessage(message *models.Message) error {
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(message).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&models.Conversation{}).
+			Where("id = ?", message.ConversationID).
+			Updates(map[string]interface{}{
+				"last_message_at":   message.CreatedAt,
+				"last_message_text": previewOf(message.Content),
+				"last_message_by":   message.SenderID,
+				"has_messages":      true,
+			}).Error
+	})
+}
+
+// messageResponse renders a stored message the way the API serves it.
+func messageResponse(message models.Message, channelID *uuid.UUID) types.MessageResponse {
+	response := types.MessageResponse{
+		ID:             message.ID.String(),
+		Content:        message.Content,
+		ContentType:    message.ContentType,
+		IsEdited:       message.IsEdited,
+		FilePath:       message.FilePath,
+		SenderID:       message.SenderID.String(),
+		CreatedAt:      message.CreatedAt.Format(time.RFC3339Nano),
+		ConversationID: message.ConversationID.String(),
+	}
+	if message.ReplyToMessageID != nil {
+		response.ReplyToMessageID = message.ReplyToMessageID.String()
+	}
+	if channelID != nil {
+		response.ChannelID = channelID.String()
+	}
+
+	if sender, err := cache.GetUser(message.SenderID); err == nil {
+		response.SenderName = sender.DisplayName
+		response.SenderAvatar = sender.ProfileImage
+	}
+
+	return response
+}
+
+// publishMessageEvent hands the event to every node through the broadcast channel.
+func publishMessageEvent(event types.Message) {
+	event.EventID = uuid.NewString()
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("failed to encode %s event: %v", event.Type, err)
+		return
+	}
+
+	if err := broker.Publish("broadcast", payload); err != nil {
+		log.Printf("failed to publish %s event: %v", event.Type, err)
+	}
+}
@@ -0,0 +1,97 @@
+package ws
+
+import (
+	"encoding/json"
+	"huddle-ws-server/broker"
+	"huddle-ws-server/presence"
+	"huddle-ws-server/types"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PublishUserStatus announces a change in the user's cluster-wide online state.
+// Coming online shows the status the user last chose, minus anything stale.
+func PublishUserStatus(userID uuid.UUID, online bool) {
+	if !online {
+		// Nobody saw the disconnect, so the last recorded activity is the best guess
+		lastActive, err := presence.Default.LastActivity(userID)
+		if err != nil || lastActive.IsZero() {
+			lastActive = time.Now()
+		}
+		publishOffline(userID, lastActive)
+		return
+	}
+
+	status, err := presence.Default.GetStatus(userID)
+	if err != nil {
+		log.Printf("failed to load status for user %s: %v", userID, err)
+		status = presence.Status{Status: presence.StatusOnline}
+	}
+
+	if status.Auto || status.Expired(time.Now()) {
+		status = presence.Status{Status: presence.StatusOnline}
+		if err := presence.Default.SetStatus(userID, status); err != nil {
+			log.Printf("failed to store status for user %s: %v", userID, err)
+		}
+	}
+
+	publishStatus(userID, status)
+}
+
+func publishOffline(userID uuid.UUID, lastActive time.Time) {
+	lastSeen.record(userID, lastActive)
+	publishPayload(types.UserStatusPayload{
+		UserId:     userID.String(),
+		Status:     presence.StatusOffline,
+		LastActive: &lastActive,
+	})
+}
+
+func publishStatus(userID uuid.UUID, status presence.Status) {
+	publishPayload(types.UserStatusPayload{
+		UserId:    userID.String(),
+		Status:    status.Visible(),
+		Emoji:     status.Emoji,
+		Text:      status.Text,
+		ExpiresAt: status.ExpiresAt,
+	})
+}
+
+func publishPayload(status types.UserStatusPayload) {
+	statusPayload, _ := json.Marshal(status)
+
+	if err := broker.Publish("user_online_status", statusPayload); err != nil {
+		log.Printf("failed to publish %s status for user %s: %v", status.Status, status.UserId, err)
+	}
+}
+
+func trackConnect(client *Client) {
+	if err := presence.Default.Touch(client.UserId, time.Now()); err != nil {
+		log.Printf("failed to record activity for user %s: %v", client.UserId, err)
+	}
+
+	cameOnline, err := presence.Default.Connect(client.UserId, client.ConnectionId)
+	if err != nil {
+		log.Printf("failed to record presence for user %s: %v", client.UserId, err)
+		return
+	}
+	if cameOnline {
+		PublishUserStatus(client.UserId, true)
+	}
+}
+
+func trackDisconnect(client *Client) {
+	now := time.Now()
+	lastSeen.record(client.UserId, now)
+
+	wentOffline, err := presence.Default.Disconnect(client.UserId, client.ConnectionId)
+	if err != nil {
+		log.Printf("failed to clear presence for user %s: %v", client.UserId, err)
+		return
+	}
+	if wentOffline {
+		publishOffline(client.UserId, now)
+	}
+}
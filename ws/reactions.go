@@ -0,0 +1,236 @@
+package ws
+
+import (
+	"errors"
+	"huddle-ws-server/cache"
+	"huddle-ws-server/database"
+	"huddle-ws-server/models"
+	"huddle-ws-server/types"
+	"log"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Enough for multi-codepoint emoji and :shortcodes:
+const maxEmojiLength = 64
+
+func handleReaction(client *Client, payload types.ReactionPayload) {
+	emoji := strings.TrimSpace(payload.Emoji)
+	if emoji == "" || utf8.RuneCountInString(emoji) > maxEmojiLength {
+		sendError(client, payload.RequestId, types.ErrorCodeInvalidRequest, "a valid emoji is required")
+		return
+	}
+
+	message, channelID, ok := loadReactableMessage(client, payload.MessageId, payload.RequestId)
+	if !ok {
+		return
+	}
+
+	reaction := models.MessageReaction{
+		MessageID: message.ID,
+		UserID:    client.UserId,
+		Emoji:     emoji,
+	}
+
+	action := "add"
+	var changed bool
+	var err error
+	if payload.Type == "add_reaction" {
+		changed, err = addReaction(&reaction)
+	} else {
+		action = "remove"
+		// Hard delete so the unique index frees the slot for a later add
+		result := database.DB.Unscoped().
+			Where("message_id = ? AND user_id = ? AND emoji = ?", message.ID, client.UserId, emoji).
+			Delete(&models.MessageReaction{})
+		changed, err = result.RowsAffected > 0, result.Error
+	}
+	if err != nil {
+		log.Printf("failed to %s reaction on message %s: %v", action, message.ID, err)
+		sendError(client, payload.RequestId, types.ErrorCodeInternal, "could not update reaction")
+		return
+	}
+
+	user, _ := cache.GetUser(client.UserId)
+	event := &types.MessageReactionEvent{
+		MessageID: message.ID.String(),
+		Action:    action,
+		Reaction: types.MessageReactionResponse{
+			Emoji:           emoji,
+			MessageID:       message.ID.String(),
+			UserId:          client.UserId.String(),
+			UserAvatar:      user.ProfileImage,
+			UserDisplayName: user.DisplayName,
+		},
+	}
+	if reaction.ID != uuid.Nil {
+		event.Reaction.ID = reaction.ID.String()
+	}
+
+	// Nothing changed, so only the sender needs to hear back
+	if changed {
+		reactions, err := reactionSummary(message.ID)
+		if err != nil {
+			log.Printf("failed to count reactions on message %s: %v", message.ID, err)
+		} else {
+			event.Reactions = reactions
+			publishMessageEvent(reactionEvent(message, channelID, event, client))
+		}
+	}
+
+	ackType := "reaction_removed"
+	if action == "add" {
+		ackType = "reaction_added"
+	}
+	client.Send(types.Message{
+		Type:     ackType,
+		Reaction: event,
+		Data:     types.AckPayload{RequestId: payload.RequestId},
+	})
+}
+
+// addReaction inserts the reaction unless the user already reacted with that
+// emoji. message_reactions belongs to the API service and may lack the unique
+// index, so the check runs under a transaction-scoped advisory lock on the
+// (message, user, emoji) triple instead of relying on ON CONFLICT alone.
+func addReaction(reaction *models.MessageReaction) (added bool, err error) {
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		lockKey := reaction.MessageID.String() + ":" + reaction.UserID.String() + ":" + reaction.Emoji
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", lockKey).Error; err != nil {
+			return err
+		}
+
+		var existing int64
+		if err := tx.Model(&models.MessageReaction{}).
+			Where("message_id = ? AND user_id = ? AND emoji = ?", reaction.MessageID, reaction.UserID, reaction.Emoji).
+			Count(&existing).Error; err != nil {
+			return err
+		}
+		if existing > 0 {
+			return nil
+		}
+
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(reaction)
+		added = result.RowsAffected > 0
+		return result.Error
+	})
+	return added, err
+}
+
+// loadReactableMessage fetches a message the client's user may react to, along
+// with the channel it was posted in, if any. Failures are reported to the client.
+func loadReactableMessage(client *Client, messageID uuid.UUID, requestID string) (models.Message, *uuid.UUID, bool) {
+	var message models.Message
+	if err := database.DB.Where("id = ?", messageID).First(&message).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			sendError(client, requestID, types.ErrorCodeInvalidRequest, "message not found")
+		} else {
+			log.Printf("failed to load message %s: %v", messageID, err)
+			sendError(client, requestID, types.ErrorCodeInternal, "could not load message")
+		}
+		return message, nil, false
+	}
+
+	var conversation models.Conversation
+	if err := database.DB.Where("id = ?", message.ConversationID).First(&conversation).Error; err != nil {
+		log.Printf("failed to load conversation %s: %v", message.ConversationID, err)
+		sendError(client, requestID, types.ErrorCodeInternal, "could not load message")
+		return message, nil, false
+	}
+
+	if conversation.ChannelID == uuid.Nil {
+		allowed, err := canAccessConversation(client.UserId, conversation.ID)
+		if err != nil {
+			log.Printf("conversation check failed for user %s: %v", client.UserId, err)
+			sendError(client, requestID, types.ErrorCodeInternal, "could not verify membership")
+			return message, nil, false
+		}
+		if !allowed {
+			sendError(client, requestID, types.ErrorCodeForbidden, "not a member of this conversation")
+			return message, nil, false
+		}
+		return message, nil, true
+	}
+
+	channelID := conversation.ChannelID
+	access, err := loadChannelAccess(client.UserId, channelID)
+	if err != nil {
+		log.Printf("channel check failed for user %s: %v", client.UserId, err)
+		sendError(client, requestID, types.ErrorCodeInternal, "could not verify membership")
+		return message, nil, false
+	}
+	if access == nil {
+		sendError(client, requestID, types.ErrorCodeForbidden, "not a member of this channel")
+		return message, nil, false
+	}
+	if !access.canPost() {
+		sendError(client, requestID, types.ErrorCodeForbidden, "you cannot react in this channel")
+		return message, nil, false
+	}
+
+	return message, &channelID, true
+}
+
+// reactionSummary tallies the message's reactions per emoji in the order they were first used.
+// HasReacted is left false because every recipient shares the frame; clients check Users.
+func reactionSummary(messageID uuid.UUID) ([]types.ReactionResponse, error) {
+	var reactions []models.MessageReaction
+	if err := database.DB.Where("message_id = ?", messageID).
+		Order("created_at").
+		Find(&reactions).Error; err != nil {
+		return nil, err
+	}
+
+	summary := make([]types.ReactionResponse, 0)
+	positions := make(map[string]int)
+	// Rows duplicated before the insert was serialized count once
+	seen := make(map[string]bool)
+	for _, reaction := range reactions {
+		key := reaction.Emoji + ":" + reaction.UserID.String()
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		position, ok := positions[reaction.Emoji]
+		if !ok {
+			position = len(summary)
+			positions[reaction.Emoji] = position
+			summary = append(summary, types.ReactionResponse{Emoji: reaction.Emoji, Users: []types.UserInfo{}})
+		}
+
+		info := types.UserInfo{ID: reaction.UserID.String()}
+		if user, err := cache.GetUser(reaction.UserID); err == nil {
+			info.Name = user.DisplayName
+			info.Avatar = user.ProfileImage
+		}
+
+		summary[position].Count++
+		summary[position].Users = append(summary[position].Users, info)
+	}
+
+	return summary, nil
+}
+
+func reactionEvent(message models.Message, channelID *uuid.UUID, reaction *types.MessageReactionEvent, client *Client) types.Message {
+	event := types.Message{
+		Type:               "reaction",
+		Reaction:           reaction,
+		OriginConnectionID: &client.ConnectionId,
+	}
+	// The reacting user's other sessions get the isMe copy
+	event.Message.SenderID = client.UserId.String()
+
+	if channelID != nil {
+		event.ChannelID = channelID
+	} else {
+		conversationID := message.ConversationID
+		event.ConversationID = &conversationID
+	}
+
+	return event
+}
@@ -0,0 +1,147 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"huddle-ws-server/broker"
+	"huddle-ws-server/database"
+	"huddle-ws-server/models"
+	"huddle-ws-server/types"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+func handleMarkRead(client *Client, payload types.MarkReadPayload) {
+	if (payload.ChannelId == nil) == (payload.ConversationId == nil) {
+		sendError(client, payload.RequestId, types.ErrorCodeInvalidRequest, "exactly one of channelId or conversationId is required")
+		return
+	}
+
+	var conversationID uuid.UUID
+	if payload.ChannelId != nil {
+		access, err := loadChannelAccess(client.UserId, *payload.ChannelId)
+		if err != nil {
+			log.Printf("channel check failed for user %s: %v", client.UserId, err)
+			sendError(client, payload.RequestId, types.ErrorCodeInternal, "could not verify membership")
+			return
+		}
+		if access == nil || !access.canRead() {
+			sendError(client, payload.RequestId, types.ErrorCodeForbidden, "not a member of this channel")
+			return
+		}
+		conversationID = access.ConversationID
+	} else {
+		allowed, err := canAccessConversation(client.UserId, *payload.ConversationId)
+		if err != nil {
+			log.Printf("conversation check failed for user %s: %v", client.UserId, err)
+			sendError(client, payload.RequestId, types.ErrorCodeInternal, "could not verify membership")
+			return
+		}
+		if !allowed {
+			sendError(client, payload.RequestId, types.ErrorCodeForbidden, "not a member of this conversation")
+			return
+		}
+		conversationID = *payload.ConversationId
+	}
+
+	query := database.DB.Where("conversation_id = ?", conversationID)
+	if payload.MessageId != nil {
+		query = query.Where("id = ?", *payload.MessageId)
+	} else {
+		query = query.Order("created_at DESC")
+	}
+
+	cursor := models.ReadCursor{
+		UserID:         client.UserId,
+		ConversationID: conversationID,
+		LastReadAt:     time.Now(),
+	}
+
+	var message models.Message
+	if err := query.First(&message).Error; err == nil {
+		cursor.LastReadMessageID = &message.ID
+		cursor.LastReadAt = message.CreatedAt
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Printf("failed to load message for read cursor: %v", err)
+		sendError(client, payload.RequestId, types.ErrorCodeInternal, "could not mark as read")
+		return
+	} else if payload.MessageId != nil {
+		sendError(client, payload.RequestId, types.ErrorCodeInvalidRequest, "message not found")
+		return
+	}
+
+	// A stale mark_read from another device never moves the cursor backwards
+	result := database.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "conversation_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"last_read_message_id", "last_read_at", "updated_at"}),
+		Where: clause.Where{Exprs: []clause.Expression{
+			clause.Expr{SQL: "read_cursors.last_read_at < excluded.last_read_at"},
+		}},
+	}).Create(&cursor)
+	if result.Error != nil {
+		log.Printf("failed to store read cursor for user %s: %v", client.UserId, result.Error)
+		sendError(client, payload.RequestId, types.ErrorCodeInternal, "could not mark as read")
+		return
+	}
+
+	// Only a cursor that moved forward is worth telling anyone about
+	if result.RowsAffected > 0 {
+		publishReadReceipt(types.Message{
+			Type:               "read_receipt",
+			ChannelID:          payload.ChannelId,
+			ConversationID:     payload.ConversationId,
+			OriginConnectionID: &client.ConnectionId,
+			Data: types.ReadReceiptPayload{
+				UserId:         client.UserId.String(),
+				ChannelId:      payload.ChannelId,
+				ConversationId: payload.ConversationId,
+				MessageId:      cursor.LastReadMessageID,
+				ReadAt:         cursor.LastReadAt,
+			},
+		})
+	}
+
+	client.Send(types.Message{
+		Type:           "marked_read",
+		ChannelID:      payload.ChannelId,
+		ConversationID: payload.ConversationId,
+		Data:           types.AckPayload{RequestId: payload.RequestId},
+	})
+}
+
+// publishReadReceipt goes out on read_events rather than broadcast: receipts
+// are neither sequenced nor shown to channel members.
+func publishReadReceipt(event types.Message) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("failed to encode read receipt: %v", err)
+		return
+	}
+
+	if err := broker.Publish("read_events", payload); err != nil {
+		log.Printf("failed to publish read receipt: %v", err)
+	}
+}
+
+// ApplyReadReceipt shows a DM receipt to the conversation and refreshes the
+// reader's own counters on this node.
+func (manager *Manager) ApplyReadReceipt(msg types.Message, receipt types.ReadReceiptPayload) {
+	readerID, err := uuid.Parse(receipt.UserId)
+	if err != nil {
+		return
+	}
+
+	topic, ok := unreadTopicFor(msg.ChannelID, msg.ConversationID)
+	if !ok {
+		return
+	}
+
+	if msg.ChannelID == nil {
+		manager.BroadcastMessage(msg)
+	}
+	unread.queue(topic, readerID)
+}
@@ -0,0 +1,155 @@
+package ws
+
+import (
+	"encoding/json"
+	"huddle-ws-server/rd"
+	"huddle-ws-server/types"
+	"log"
+
+	"github.com/google/uuid"
+)
+
+// topicFor names the ordering scope of a message: its channel, else its conversation.
+func topicFor(channelID *uuid.UUID, conversationID *uuid.UUID) string {
+	switch {
+	case channelID != nil:
+		return "channel:" + channelID.String()
+	case conversationID != nil:
+		return "conversation:" + conversationID.String()
+	}
+	return ""
+}
+
+// isEphemeral marks events that are neither sequenced, replayed nor echoed to the sender.
+func isEphemeral(msg types.Message) bool {
+	return msg.Type == "typing" || msg.Type == "stop_typing"
+}
+
+// sequenceTopic is the topic msg is ordered on. Events routed to users,
+// teams or everyone are never sequenced, even when they carry a channelId,
+// so they cannot leak to a channel's subscribers through replay.
+func sequenceTopic(msg types.Message) string {
+	switch msg.Route {
+	case "":
+		// Ambiguous; resolveRoute rejects it
+		if msg.ChannelID != nil && msg.TeamID != nil {
+			return ""
+		}
+		return topicFor(msg.ChannelID, msg.ConversationID)
+	case types.RouteChannel:
+		return topicFor(msg.ChannelID, nil)
+	case types.RouteConversation:
+		return topicFor(nil, msg.ConversationID)
+	}
+	return ""
+}
+
+// StampSequence assigns msg the next sequence number of its topic and logs
+// it for replay. payload is the event exactly as it was published and
+// brokerID the ID the broker gave it, if any.
+func StampSequence(msg *types.Message, payload []byte, brokerID string) {
+	topic := sequenceTopic(*msg)
+	if topic == "" || isEphemeral(*msg) || !rd.Available() {
+		return
+	}
+
+	// Events published by the backend carry no event ID, so fall back to the
+	// stream entry ID, which every node shares, or else to the payload itself
+	var seq int64
+	var err error
+	switch {
+	case msg.EventID != "":
+		seq, err = rd.Sequence(topic, msg.EventID, payload)
+	case brokerID != "":
+		seq, err = rd.Sequence(topic, "entry:"+brokerID, payload)
+	default:
+		seq, err = rd.SequencePayload(topic, payload)
+	}
+	if err != nil {
+		log.Printf("failed to sequence event on %s: %v", topic, err)
+		return
+	}
+	msg.Seq = seq
+}
+
+func (manager *Manager) isSubscribed(client *Client, channelID *uuid.UUID, conversationID *uuid.UUID) bool {
+	shard := manager.shardFor(client.UserId)
+	shard.mutex.RLock()
+	defer shard.mutex.RUnlock()
+
+	if channelID != nil {
+		return client.Channels[*channelID]
+	}
+	return conversationID != nil && client.DirectMessages[*conversationID]
+}
+
+// handleResume replays what the client missed on each topic before letting live traffic through.
+func handleResume(client *Client, payload types.ResumePayload) {
+	replayed := make(map[string]int64)
+
+	client.beginReplay()
+	defer client.endReplay(replayed)
+
+	for _, resumeTopic := range payload.Topics {
+		if !WsManager.isSubscribed(client, resumeTopic.ChannelId, resumeTopic.ConversationId) {
+			sendError(client, payload.RequestId, types.ErrorCodeForbidden, "not subscribed to this channel or conversation")
+			continue
+		}
+
+		topic := topicFor(resumeTopic.ChannelId, resumeTopic.ConversationId)
+		if !rd.Available() {
+			sendReplay(client, types.Message{
+				Type:           "resync_required",
+				ChannelID:      resumeTopic.ChannelId,
+				ConversationID: resumeTopic.ConversationId,
+			})
+			continue
+		}
+
+		entries, ok, err := rd.Replay(topic, resumeTopic.LastSeq)
+		if err != nil {
+			log.Printf("failed to replay %s for user %s: %v", topic, client.UserId, err)
+		}
+		if err != nil || !ok {
+			sendReplay(client, types.Message{
+				Type:           "resync_required",
+				ChannelID:      resumeTopic.ChannelId,
+				ConversationID: resumeTopic.ConversationId,
+			})
+			continue
+		}
+
+		for _, entry := range entries {
+			var msg types.Message
+			if err := json.Unmarshal([]byte(entry.Payload), &msg); err != nil {
+				continue
+			}
+			// Replayed frames get the same routing checks and stripping as live ones
+			msg.UserIds = nil
+			msg.Admin = nil
+			if err := WsManager.resolveRoute(&msg); err != nil || sequenceTopic(msg) != topic {
+				continue
+			}
+
+			msg.Seq = entry.Seq
+			msg.Message.IsMe = msg.Message.SenderID == client.UserId.String()
+
+			sendReplay(client, msg)
+			replayed[topic] = entry.Seq
+		}
+	}
+
+	sendReplay(client, types.Message{
+		Type: "resumed",
+		Data: types.AckPayload{RequestId: payload.RequestId},
+	})
+}
+
+func sendReplay(client *Client, msg types.Message) {
+	frame, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("failed to encode replay frame: %v", err)
+		return
+	}
+	client.enqueueReplay(frame)
+}
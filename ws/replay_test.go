@@ -0,0 +1,144 @@
+package ws
+
+import (
+	"fmt"
+	"huddle-ws-server/types"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestSequenceTopic(t *testing.T) {
+	channelID := uuid.New()
+	conversationID := uuid.New()
+	teamID := uuid.New()
+
+	tests := []struct {
+		name string
+		msg  types.Message
+		want string
+	}{
+		{name: "inferred channel", msg: types.Message{ChannelID: &channelID}, want: "channel:" + channelID.String()},
+		{name: "inferred conversation", msg: types.Message{ConversationID: &conversationID}, want: "conversation:" + conversationID.String()},
+		{name: "channel route", msg: types.Message{Route: types.RouteChannel, ChannelID: &channelID, ConversationID: &conversationID}, want: "channel:" + channelID.String()},
+		{name: "conversation route", msg: types.Message{Route: types.RouteConversation, ChannelID: &channelID, ConversationID: &conversationID}, want: "conversation:" + conversationID.String()},
+		{name: "users route with channel", msg: types.Message{Route: types.RouteUsers, UserIds: []uuid.UUID{uuid.New()}, ChannelID: &channelID}},
+		{name: "team route with channel", msg: types.Message{Route: types.RouteTeam, TeamID: &teamID, ChannelID: &channelID}},
+		{name: "global route with conversation", msg: types.Message{Route: types.RouteGlobal, ConversationID: &conversationID}},
+		{name: "no topic", msg: types.Message{TeamID: &teamID}},
+		{name: "channel and team without route", msg: types.Message{ChannelID: &channelID, TeamID: &teamID}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := sequenceTopic(test.msg); got != test.want {
+				t.Errorf("sequenceTopic = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestReplayHoldsLiveFrames(t *testing.T) {
+	tests := []struct {
+		name     string
+		live     []outbound
+		replayed map[string]int64
+		want     []string
+	}{
+		{
+			name:     "unsequenced frames are released",
+			live:     []outbound{{frame: []byte("presence")}},
+			replayed: map[string]int64{"channel:a": 3},
+			want:     []string{"replay", "presence"},
+		},
+		{
+			name: "frames covered by the replay are skipped",
+			live: []outbound{
+				{frame: []byte("a2"), topic: "channel:a", seq: 2},
+				{frame: []byte("a3"), topic: "channel:a", seq: 3},
+				{frame: []byte("a4"), topic: "channel:a", seq: 4},
+			},
+			replayed: map[string]int64{"channel:a": 3},
+			want:     []string{"replay", "a4"},
+		},
+		{
+			name: "other topics are released",
+			live: []outbound{
+				{frame: []byte("a1"), topic: "channel:a", seq: 1},
+				{frame: []byte("b1"), topic: "channel:b", seq: 1},
+			},
+			replayed: map[string]int64{"channel:a": 1},
+			want:     []string{"replay", "b1"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			manager := NewManager(Config{SendQueueSize: 8, OverflowPolicy: DropNewest})
+			client := manager.NewClient(nil, uuid.New())
+
+			client.beginReplay()
+			for _, out := range test.live {
+				if !client.enqueueOutbound(out) {
+					t.Fatalf("enqueueOutbound(%s) refused while replaying", out.frame)
+				}
+			}
+			client.enqueueReplay([]byte("replay"))
+			client.endReplay(test.replayed)
+
+			got := drain(client)
+			if len(got) != len(test.want) {
+				t.Fatalf("frames = %q, want %q", got, test.want)
+			}
+			for i := range got {
+				if got[i] != test.want[i] {
+					t.Fatalf("frames = %q, want %q", got, test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestReplayWaitsForWriter(t *testing.T) {
+	const frames = 10
+
+	manager := NewManager(Config{SendQueueSize: 2, OverflowPolicy: Disconnect})
+	client := manager.NewClient(nil, uuid.New())
+	client.beginReplay()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < frames; i++ {
+			if !client.enqueueReplay([]byte(fmt.Sprint(i))) {
+				t.Errorf("enqueueReplay(%d) failed", i)
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < frames; i++ {
+		if got := string(<-client.send); got != fmt.Sprint(i) {
+			t.Fatalf("frame %d = %q", i, got)
+		}
+	}
+	<-done
+
+	if client.isClosed() || client.Dropped() != 0 {
+		t.Fatalf("closed = %v, dropped = %d; want an open client with no drops", client.isClosed(), client.Dropped())
+	}
+}
+
+func TestReplayStopsWhenClosed(t *testing.T) {
+	manager := NewManager(Config{SendQueueSize: 1, OverflowPolicy: DropNewest})
+	client := manager.NewClient(nil, uuid.New())
+	client.beginReplay()
+
+	if !client.enqueueReplay([]byte("first")) {
+		t.Fatal("enqueueReplay into an empty queue failed")
+	}
+	client.close()
+	if client.enqueueReplay([]byte("second")) {
+		t.Fatal("enqueueReplay succeeded on a closed client with a full queue")
+	}
+}
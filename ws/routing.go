@@ -0,0 +1,172 @@
+package ws
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"huddle-ws-server/types"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Signed announcements older or newer than this are refused as replays
+const maxAnnouncementSkew = 5 * time.Minute
+
+// resolveRoute checks that msg names its recipients, inferring the route
+// from the IDs it carries for publishers that predate routes. A message with
+// both a channel and a team has to say which one it is for: guessing wrong
+// either leaks channel traffic to the team or sends team news to nobody.
+func (manager *Manager) resolveRoute(msg *types.Message) error {
+	if msg.Route == "" {
+		switch {
+		case msg.ChannelID != nil && msg.TeamID != nil:
+			return errors.New("channelId and teamId without a route")
+		case msg.ChannelID != nil:
+			msg.Route = types.RouteChannel
+		case msg.ConversationID != nil:
+			msg.Route = types.RouteConversation
+		case msg.TeamID != nil:
+			msg.Route = types.RouteTeam
+		default:
+			return errors.New("no route, channelId, conversationId or teamId")
+		}
+	}
+
+	switch msg.Route {
+	case types.RouteChannel:
+		if msg.ChannelID == nil {
+			return errors.New("channel route without channelId")
+		}
+	case types.RouteConversation:
+		if msg.ConversationID == nil {
+			return errors.New("conversation route without conversationId")
+		}
+	case types.RouteUsers:
+		if len(msg.UserIds) == 0 {
+			return errors.New("users route without userIds")
+		}
+	case types.RouteTeam:
+		if msg.TeamID == nil {
+			return errors.New("team route without teamId")
+		}
+	case types.RouteGlobal:
+		return manager.verifyAnnouncement(msg)
+	default:
+		return fmt.Errorf("unknown route %q", msg.Route)
+	}
+	return nil
+}
+
+func (manager *Manager) verifyAnnouncement(msg *types.Message) error {
+	if manager.config.BroadcastSecret == "" {
+		return errors.New("global announcements are disabled")
+	}
+	if msg.Admin == nil {
+		return errors.New("global route without admin signature")
+	}
+
+	issuedAt := time.Unix(msg.Admin.IssuedAt, 0)
+	if skew := time.Since(issuedAt); skew > maxAnnouncementSkew || skew < -maxAnnouncementSkew {
+		return errors.New("admin signature expired")
+	}
+
+	signature, err := hex.DecodeString(msg.Admin.Signature)
+	if err != nil {
+		return errors.New("malformed admin signature")
+	}
+
+	expected, err := announcementMAC(manager.config.BroadcastSecret, *msg, msg.Admin.IssuedAt)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(signature, expected) {
+		return errors.New("invalid admin signature")
+	}
+	return nil
+}
+
+// SignAnnouncement routes msg globally and signs it with secret, for
+// publishers holding WS_BROADCAST_SECRET.
+func SignAnnouncement(secret string, msg *types.Message, issuedAt time.Time) error {
+	msg.Route = types.RouteGlobal
+	msg.Admin = &types.AdminSignature{IssuedAt: issuedAt.Unix()}
+
+	mac, err := announcementMAC(secret, *msg, msg.Admin.IssuedAt)
+	if err != nil {
+		return err
+	}
+	msg.Admin.Signature = hex.EncodeToString(mac)
+	return nil
+}
+
+// announcementMAC covers everything recipients see. The message is encoded
+// without its signature or seq and decoded into generic maps, so re-encoding
+// yields sorted keys no matter how the publisher ordered them.
+func announcementMAC(secret string, msg types.Message, issuedAt int64) ([]byte, error) {
+	msg.Seq = 0
+	msg.Admin = &types.AdminSignature{IssuedAt: issuedAt}
+
+	encoded, err := json.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("encode announcement: %w", err)
+	}
+	var generic interface{}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	if err := decoder.Decode(&generic); err != nil {
+		return nil, fmt.Errorf("encode announcement: %w", err)
+	}
+	canonical, err := json.Marshal(generic)
+	if err != nil {
+		return nil, fmt.Errorf("encode announcement: %w", err)
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(canonical)
+	return mac.Sum(nil), nil
+}
+
+// forEachRecipient visits the local clients msg is routed to. msg must have
+// been through resolveRoute.
+func (manager *Manager) forEachRecipient(msg types.Message, fn func(client *Client)) {
+	switch msg.Route {
+	case types.RouteUsers:
+		seen := make(map[uuid.UUID]bool, len(msg.UserIds))
+		for _, userID := range msg.UserIds {
+			if seen[userID] {
+				continue
+			}
+			seen[userID] = true
+
+			shard := manager.shardFor(userID)
+			shard.mutex.RLock()
+			for _, client := range shard.userConns[userID] {
+				fn(client)
+			}
+			shard.mutex.RUnlock()
+		}
+	case types.RouteTeam:
+		for _, shard := range manager.shards {
+			shard.mutex.RLock()
+			for client := range shard.teamSubs[*msg.TeamID] {
+				fn(client)
+			}
+			shard.mutex.RUnlock()
+		}
+	case types.RouteGlobal:
+		for _, shard := range manager.shards {
+			shard.mutex.RLock()
+			for client := range shard.clients {
+				fn(client)
+			}
+			shard.mutex.RUnlock()
+		}
+	default:
+		manager.forEachSubscriber(msg, fn)
+	}
+}
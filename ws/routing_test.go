@@ -0,0 +1,193 @@
+package ws
+
+import (
+	"encoding/json"
+	"huddle-ws-server/types"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const testSecret = "test-broadcast-secret"
+
+// signedAnnouncement returns a global announcement signed at issuedAt, as it
+// arrives after a trip through the broker.
+func signedAnnouncement(t *testing.T, issuedAt time.Time) types.Message {
+	t.Helper()
+
+	msg := types.Message{
+		Type: "announcement",
+		Data: map[string]interface{}{"text": "maintenance at noon", "level": 2},
+	}
+	if err := SignAnnouncement(testSecret, &msg, issuedAt); err != nil {
+		t.Fatalf("SignAnnouncement: %v", err)
+	}
+
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	var received types.Message
+	if err := json.Unmarshal(payload, &received); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	return received
+}
+
+func TestVerifyAnnouncement(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		secret  string
+		msg     func(t *testing.T) types.Message
+		wantErr bool
+	}{
+		{
+			name:   "accepted",
+			secret: testSecret,
+			msg:    func(t *testing.T) types.Message { return signedAnnouncement(t, now) },
+		},
+		{
+			name:    "announcements disabled",
+			secret:  "",
+			msg:     func(t *testing.T) types.Message { return signedAnnouncement(t, now) },
+			wantErr: true,
+		},
+		{
+			name:    "wrong secret",
+			secret:  "another-secret",
+			msg:     func(t *testing.T) types.Message { return signedAnnouncement(t, now) },
+			wantErr: true,
+		},
+		{
+			name:   "missing signature",
+			secret: testSecret,
+			msg: func(t *testing.T) types.Message {
+				msg := signedAnnouncement(t, now)
+				msg.Admin = nil
+				return msg
+			},
+			wantErr: true,
+		},
+		{
+			name:   "malformed signature",
+			secret: testSecret,
+			msg: func(t *testing.T) types.Message {
+				msg := signedAnnouncement(t, now)
+				msg.Admin.Signature = "not-hex"
+				return msg
+			},
+			wantErr: true,
+		},
+		{
+			name:   "tampered data",
+			secret: testSecret,
+			msg: func(t *testing.T) types.Message {
+				msg := signedAnnouncement(t, now)
+				msg.Data.(map[string]interface{})["text"] = "click this link"
+				return msg
+			},
+			wantErr: true,
+		},
+		{
+			name:   "tampered message body",
+			secret: testSecret,
+			msg: func(t *testing.T) types.Message {
+				msg := signedAnnouncement(t, now)
+				msg.Message.Content = "click this link"
+				return msg
+			},
+			wantErr: true,
+		},
+		{
+			name:   "tampered type",
+			secret: testSecret,
+			msg: func(t *testing.T) types.Message {
+				msg := signedAnnouncement(t, now)
+				msg.Type = "force_logout"
+				return msg
+			},
+			wantErr: true,
+		},
+		{
+			name:   "issuedAt moved forward",
+			secret: testSecret,
+			msg: func(t *testing.T) types.Message {
+				msg := signedAnnouncement(t, now.Add(-time.Hour))
+				msg.Admin.IssuedAt = now.Unix()
+				return msg
+			},
+			wantErr: true,
+		},
+		{
+			name:   "expired",
+			secret: testSecret,
+			msg: func(t *testing.T) types.Message {
+				return signedAnnouncement(t, now.Add(-maxAnnouncementSkew-time.Minute))
+			},
+			wantErr: true,
+		},
+		{
+			name:   "issued in the future",
+			secret: testSecret,
+			msg: func(t *testing.T) types.Message {
+				return signedAnnouncement(t, now.Add(maxAnnouncementSkew+time.Minute))
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			manager := NewManager(Config{BroadcastSecret: test.secret})
+			msg := test.msg(t)
+
+			err := manager.resolveRoute(&msg)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("resolveRoute error = %v, wantErr %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestResolveRoute(t *testing.T) {
+	id := uuid.New()
+
+	tests := []struct {
+		name      string
+		msg       types.Message
+		wantRoute string
+		wantErr   bool
+	}{
+		{name: "inferred channel", msg: types.Message{ChannelID: &id}, wantRoute: types.RouteChannel},
+		{name: "inferred conversation", msg: types.Message{ConversationID: &id}, wantRoute: types.RouteConversation},
+		{name: "inferred team", msg: types.Message{TeamID: &id}, wantRoute: types.RouteTeam},
+		{name: "channel and team without route", msg: types.Message{ChannelID: &id, TeamID: &id}, wantErr: true},
+		{name: "channel and team routed to team", msg: types.Message{Route: types.RouteTeam, ChannelID: &id, TeamID: &id}, wantRoute: types.RouteTeam},
+		{name: "channel and team routed to channel", msg: types.Message{Route: types.RouteChannel, ChannelID: &id, TeamID: &id}, wantRoute: types.RouteChannel},
+		{name: "explicit users", msg: types.Message{Route: types.RouteUsers, UserIds: []uuid.UUID{id}, ChannelID: &id}, wantRoute: types.RouteUsers},
+		{name: "no recipients", msg: types.Message{}, wantErr: true},
+		{name: "channel route without channel", msg: types.Message{Route: types.RouteChannel, TeamID: &id}, wantErr: true},
+		{name: "conversation route without conversation", msg: types.Message{Route: types.RouteConversation}, wantErr: true},
+		{name: "users route without users", msg: types.Message{Route: types.RouteUsers}, wantErr: true},
+		{name: "team route without team", msg: types.Message{Route: types.RouteTeam}, wantErr: true},
+		{name: "unsigned global", msg: types.Message{Route: types.RouteGlobal}, wantErr: true},
+		{name: "unknown route", msg: types.Message{Route: "everyone", ChannelID: &id}, wantErr: true},
+	}
+
+	manager := NewManager(Config{BroadcastSecret: testSecret})
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			msg := test.msg
+			err := manager.resolveRoute(&msg)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("resolveRoute error = %v, wantErr %v", err, test.wantErr)
+			}
+			if err == nil && msg.Route != test.wantRoute {
+				t.Errorf("route = %q, want %q", msg.Route, test.wantRoute)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package ws
+
+import (
+	"hash/fnv"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// shard owns a slice of the connected users. All of a user's connections
+// live on the same shard, so per-user state never spans two locks.
+type shard struct {
+	clients   map[*Client]bool
+	userConns map[uuid.UUID][]*Client
+	// Reverse indexes so a broadcast only touches the topic's subscribers
+	channelSubs      map[uuid.UUID]map[*Client]bool
+	conversationSubs map[uuid.UUID]map[*Client]bool
+	teamSubs         map[uuid.UUID]map[*Client]bool
+	register         chan *Client
+	unregister       chan *Client
+	mutex            sync.RWMutex
+}
+
+func newShard() *shard {
+	return &shard{
+		clients:          make(map[*Client]bool),
+		userConns:        make(map[uuid.UUID][]*Client),
+		channelSubs:      make(map[uuid.UUID]map[*Client]bool),
+		conversationSubs: make(map[uuid.UUID]map[*Client]bool),
+		teamSubs:         make(map[uuid.UUID]map[*Client]bool),
+		register:         make(chan *Client),
+		unregister:       make(chan *Client),
+	}
+}
+
+func shardIndex(userID uuid.UUID, shardCount int) int {
+	hash := fnv.New32a()
+	hash.Write(userID[:])
+	return int(hash.Sum32() % uint32(shardCount))
+}
+
+func (shard *shard) run() {
+	for {
+		select {
+		case client := <-shard.register:
+			shard.mutex.Lock()
+			shard.addClient(client)
+			shard.mutex.Unlock()
+
+		case client := <-shard.unregister:
+			shard.mutex.Lock()
+			if _, ok := shard.clients[client]; ok {
+				shard.removeClient(client)
+				client.close()
+			}
+			shard.mutex.Unlock()
+		}
+	}
+}
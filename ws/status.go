@@ -0,0 +1,251 @@
+package ws
+
+import (
+	"huddle-ws-server/database"
+	"huddle-ws-server/models"
+	"huddle-ws-server/presence"
+	"huddle-ws-server/types"
+	"log"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+const (
+	idleCheckInterval = 30 * time.Second
+	maxStatusText     = 100
+)
+
+func handleSetStatus(client *Client, payload types.SetStatusPayload) {
+	if !presence.IsSelectable(payload.Status) {
+		sendError(client, payload.RequestId, types.ErrorCodeInvalidRequest, "status must be online, away, dnd or invisible")
+		return
+	}
+	if utf8.RuneCountInString(payload.Text) > maxStatusText {
+		sendError(client, payload.RequestId, types.ErrorCodeInvalidRequest, "status text is too long")
+		return
+	}
+	if payload.ExpiresAt != nil && !payload.ExpiresAt.After(time.Now()) {
+		sendError(client, payload.RequestId, types.ErrorCodeInvalidRequest, "expiresAt must be in the future")
+		return
+	}
+
+	status := presence.Status{
+		Status:    payload.Status,
+		Emoji:     payload.Emoji,
+		Text:      payload.Text,
+		ExpiresAt: payload.ExpiresAt,
+	}
+	if err := presence.Default.SetStatus(client.UserId, status); err != nil {
+		log.Printf("failed to store status for user %s: %v", client.UserId, err)
+		sendError(client, payload.RequestId, types.ErrorCodeInternal, "could not update status")
+		return
+	}
+
+	persistStatus(client.UserId, status.Status)
+	publishStatus(client.UserId, status)
+
+	client.Send(types.Message{
+		Type: "status_set",
+		Data: types.AckPayload{RequestId: payload.RequestId},
+	})
+}
+
+func persistStatus(userID uuid.UUID, status string) {
+	if err := database.DB.Model(&models.User{}).
+		Where("id = ?", userID).
+		Updates(map[string]interface{}{
+			"status":      status,
+			"last_active": time.Now(),
+		}).Error; err != nil {
+		log.Printf("failed to persist status for user %s: %v", userID, err)
+	}
+}
+
+// recordActivity is called for every frame a client sends. A client that was
+// idle wakes its user up straight away instead of waiting for the next sweep.
+func (manager *Manager) recordActivity(client *Client) {
+	now := time.Now()
+	previous := time.Unix(0, client.lastActivity.Swap(now.UnixNano()))
+
+	if now.Sub(previous) >= manager.config.IdleAfter {
+		go func() {
+			if err := presence.Default.Touch(client.UserId, now); err != nil {
+				log.Printf("failed to record activity for user %s: %v", client.UserId, err)
+			}
+			manager.refreshStatus(client.UserId, now)
+		}()
+	}
+}
+
+// localActivity returns the latest activity of each user connected to this node.
+func (manager *Manager) localActivity() map[uuid.UUID]time.Time {
+	activity := make(map[uuid.UUID]time.Time)
+
+	for _, shard := range manager.shards {
+		shard.mutex.RLock()
+		for userID, clients := range shard.userConns {
+			for _, client := range clients {
+				lastActivity := time.Unix(0, client.lastActivity.Load())
+				if lastActivity.After(activity[userID]) {
+					activity[userID] = lastActivity
+				}
+			}
+		}
+		shard.mutex.RUnlock()
+	}
+
+	return activity
+}
+
+// watchIdle shares local activity with the cluster and moves users between
+// online and away as they go idle and come back.
+func (manager *Manager) watchIdle() {
+	ticker := time.NewTicker(idleCheckInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		for userID, lastActivity := range manager.localActivity() {
+			if now.Sub(lastActivity) < idleCheckInterval {
+				if err := presence.Default.Touch(userID, lastActivity); err != nil {
+					log.Printf("failed to record activity for user %s: %v", userID, err)
+				}
+			}
+			manager.refreshStatus(userID, now)
+		}
+	}
+}
+
+func (manager *Manager) refreshStatus(userID uuid.UUID, now time.Time) {
+	status, err := presence.Default.GetStatus(userID)
+	if err != nil {
+		log.Printf("failed to load status for user %s: %v", userID, err)
+		return
+	}
+
+	previous := status
+	changed := false
+	if status.Expired(now) {
+		status = presence.Status{Status: presence.StatusOnline}
+		changed = true
+	}
+
+	lastActivity, err := presence.Default.LastActivity(userID)
+	if err != nil {
+		log.Printf("failed to load activity for user %s: %v", userID, err)
+		return
+	}
+	idle := now.Sub(lastActivity) >= manager.config.IdleAfter
+
+	switch {
+	case idle && status.Status == presence.StatusOnline:
+		status.Status = presence.StatusAway
+		status.Auto = true
+		changed = true
+	case !idle && status.Auto:
+		status.Status = presence.StatusOnline
+		status.Auto = false
+		changed = true
+	}
+
+	if !changed {
+		return
+	}
+
+	// Every node hosting the user sees the same transition; only the node
+	// whose swap lands publishes it and writes it to the database
+	swapped, err := presence.Default.SwapStatus(userID, previous, status)
+	if err != nil {
+		log.Printf("failed to store status for user %s: %v", userID, err)
+		return
+	}
+	if !swapped {
+		return
+	}
+	persistStatus(userID, status.Status)
+	publishStatus(userID, status)
+}
+
+const maxPresenceLookup = 200
+
+// handleGetPresence answers with status and last seen for the requested
+// users, leaving out anyone outside the requester's presence audience.
+func handleGetPresence(client *Client, payload types.GetPresencePayload) {
+	if len(payload.UserIds) == 0 || len(payload.UserIds) > maxPresenceLookup {
+		sendError(client, payload.RequestId, types.ErrorCodeInvalidRequest, "userIds must list between 1 and 200 users")
+		return
+	}
+
+	audience, err := presenceAudience.get(client.UserId)
+	if err != nil {
+		log.Printf("failed to load presence audience for user %s: %v", client.UserId, err)
+		sendError(client, payload.RequestId, types.ErrorCodeInternal, "could not load presence")
+		return
+	}
+
+	visible := make(map[uuid.UUID]bool, len(audience))
+	for _, userID := range audience {
+		visible[userID] = true
+	}
+
+	userIDs := make([]uuid.UUID, 0, len(payload.UserIds))
+	for _, userID := range payload.UserIds {
+		if visible[userID] {
+			userIDs = append(userIDs, userID)
+		}
+	}
+
+	var users []models.User
+	if len(userIDs) > 0 {
+		if err := database.DB.Select("id", "last_active").Where("id IN ?", userIDs).Find(&users).Error; err != nil {
+			log.Printf("failed to load last seen: %v", err)
+			sendError(client, payload.RequestId, types.ErrorCodeInternal, "could not load presence")
+			return
+		}
+	}
+
+	result := types.PresenceResult{
+		Users:     make([]types.UserStatusPayload, 0, len(users)),
+		RequestId: payload.RequestId,
+	}
+	for _, user := range users {
+		result.Users = append(result.Users, presenceOf(user))
+	}
+
+	client.Send(types.Message{
+		Type: "presence",
+		Data: result,
+	})
+}
+
+func presenceOf(user models.User) types.UserStatusPayload {
+	lastActive := user.LastActive
+	if pending := lastSeen.get(user.ID); pending.After(lastActive) {
+		lastActive = pending
+	}
+
+	entry := types.UserStatusPayload{
+		UserId: user.ID.String(),
+		Status: presence.StatusOffline,
+	}
+	if !lastActive.IsZero() {
+		entry.LastActive = &lastActive
+	}
+
+	online, err := presence.Default.IsOnline(user.ID)
+	if err != nil || !online {
+		return entry
+	}
+
+	status, err := presence.Default.GetStatus(user.ID)
+	if err != nil || status.Visible() == presence.StatusOffline {
+		return entry
+	}
+
+	entry.Status = status.Status
+	entry.Emoji = status.Emoji
+	entry.Text = status.Text
+	entry.ExpiresAt = status.ExpiresAt
+	return entry
+}
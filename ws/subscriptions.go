@@ -0,0 +1,59 @@
+package ws
+
+import (
+	"huddle-ws-server/types"
+	"log"
+)
+
+func handleSubscription(client *Client, payload types.SubscriptionPayload) {
+	if (payload.ChannelId == nil) == (payload.ConversationId == nil) {
+		sendError(client, payload.RequestId, types.ErrorCodeInvalidRequest, "exactly one of channelId or conversationId is required")
+		return
+	}
+
+	subscribe := payload.Type == "subscribe"
+
+	// Unsubscribing never needs authorization
+	if subscribe {
+		var allowed bool
+		var err error
+		if payload.ChannelId != nil {
+			allowed, err = canAccessChannel(client.UserId, *payload.ChannelId)
+		} else {
+			allowed, err = canAccessConversation(client.UserId, *payload.ConversationId)
+		}
+
+		if err != nil {
+			log.Printf("subscription check failed for user %s: %v", client.UserId, err)
+			sendError(client, payload.RequestId, types.ErrorCodeInternal, "could not verify membership")
+			return
+		}
+		if !allowed {
+			sendError(client, payload.RequestId, types.ErrorCodeForbidden, "not a member of this channel or conversation")
+			return
+		}
+	}
+
+	switch {
+	case payload.ChannelId != nil && subscribe:
+		WsManager.SubscribeToChannel(client, *payload.ChannelId)
+	case payload.ChannelId != nil:
+		WsManager.UnsubscribeFromChannel(client, *payload.ChannelId)
+	case subscribe:
+		WsManager.SubscribeToConversation(client, *payload.ConversationId)
+	default:
+		WsManager.UnsubscribeFromConversation(client, *payload.ConversationId)
+	}
+
+	ackType := "unsubscribed"
+	if subscribe {
+		ackType = "subscribed"
+	}
+
+	client.Send(types.Message{
+		Type:           ackType,
+		ChannelID:      payload.ChannelId,
+		ConversationID: payload.ConversationId,
+		Data:           types.AckPayload{RequestId: payload.RequestId},
+	})
+}
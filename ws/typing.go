@@ -0,0 +1,189 @@
+package ws
+
+import (
+	"encoding/json"
+	"huddle-ws-server/broker"
+	"huddle-ws-server/cache"
+	"huddle-ws-server/types"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// A user's typing frames are forwarded at most once per typingThrottle per topic
+	typingThrottle = 3 * time.Second
+	// Without a fresh typing frame the indicator is cleared after typingTimeout
+	typingTimeout = 6 * time.Second
+)
+
+type typingKey struct {
+	userID uuid.UUID
+	topic  string
+}
+
+type typingState struct {
+	payload      types.TypingPayload
+	connectionID uuid.UUID
+	lastSent     time.Time
+	timer        *time.Timer
+	// Identifies the timer currently armed, so a stale one cannot clear a fresh indicator
+	generation uint64
+}
+
+// typingTracker throttles typing indicators and clears them when the client
+// stops sending, goes quiet or disconnects.
+type typingTracker struct {
+	mutex      sync.Mutex
+	active     map[typingKey]*typingState
+	generation uint64
+}
+
+var typing = &typingTracker{active: make(map[typingKey]*typingState)}
+
+func handleTyping(client *Client, payload types.TypingPayload) {
+	topic := topicFor(payload.ChannelId, payload.ConversationId)
+	if topic == "" || !WsManager.isSubscribed(client, payload.ChannelId, payload.ConversationId) {
+		return
+	}
+
+	key := typingKey{userID: client.UserId, topic: topic}
+	if payload.Type == "stop_typing" {
+		typing.stop(key)
+		return
+	}
+	typing.start(key, client, payload)
+}
+
+func (tracker *typingTracker) start(key typingKey, client *Client, payload types.TypingPayload) {
+	tracker.mutex.Lock()
+	state, ok := tracker.active[key]
+	if ok {
+		state.connectionID = client.ConnectionId
+		tracker.arm(key, state)
+		if time.Since(state.lastSent) < typingThrottle {
+			tracker.mutex.Unlock()
+			return
+		}
+		state.lastSent = time.Now()
+		payload = state.payload
+		tracker.mutex.Unlock()
+
+		publishTyping(payload, client.ConnectionId)
+		return
+	}
+	tracker.mutex.Unlock()
+
+	// Only the first frame of a burst pays for the permission check and profile lookup
+	if payload.ChannelId != nil {
+		access, err := loadChannelAccess(client.UserId, *payload.ChannelId)
+		if err != nil || access == nil || !access.canPost() {
+			return
+		}
+	}
+
+	user, err := cache.GetUser(client.UserId)
+	if err != nil {
+		return
+	}
+
+	payload.Type = "typing"
+	payload.UserID = client.UserId.String()
+	payload.UserAvatar = &user.ProfileImage
+	payload.UserDisplayName = &user.DisplayName
+
+	tracker.mutex.Lock()
+	if _, raced := tracker.active[key]; raced {
+		tracker.mutex.Unlock()
+		return
+	}
+	state = &typingState{
+		payload:      payload,
+		connectionID: client.ConnectionId,
+		lastSent:     time.Now(),
+	}
+	tracker.arm(key, state)
+	tracker.active[key] = state
+	tracker.mutex.Unlock()
+
+	publishTyping(payload, client.ConnectionId)
+}
+
+// arm (re)starts the expiry timer. Callers must hold the mutex.
+func (tracker *typingTracker) arm(key typingKey, state *typingState) {
+	if state.timer != nil {
+		state.timer.Stop()
+	}
+	tracker.generation++
+	generation := tracker.generation
+	state.generation = generation
+	state.timer = time.AfterFunc(typingTimeout, func() { tracker.clear(key, generation) })
+}
+
+// stop clears the indicator and tells everyone else.
+func (tracker *typingTracker) stop(key typingKey) {
+	tracker.clear(key, 0)
+}
+
+// clear removes the indicator; a non-zero generation only matches the timer
+// that armed it, so an expiry that lost the race with a fresh frame is ignored.
+func (tracker *typingTracker) clear(key typingKey, generation uint64) {
+	tracker.mutex.Lock()
+	state, ok := tracker.active[key]
+	if ok && generation != 0 && state.generation != generation {
+		ok = false
+	}
+	if ok {
+		state.timer.Stop()
+		delete(tracker.active, key)
+	}
+	tracker.mutex.Unlock()
+
+	if !ok {
+		return
+	}
+
+	payload := state.payload
+	payload.Type = "stop_typing"
+	publishTyping(payload, state.connectionID)
+}
+
+// stopConnection clears every indicator last driven by the closing connection.
+func (tracker *typingTracker) stopConnection(client *Client) {
+	tracker.mutex.Lock()
+	keys := make([]typingKey, 0)
+	for key, state := range tracker.active {
+		if key.userID == client.UserId && state.connectionID == client.ConnectionId {
+			keys = append(keys, key)
+		}
+	}
+	tracker.mutex.Unlock()
+
+	for _, key := range keys {
+		tracker.stop(key)
+	}
+}
+
+// publishTyping routes the event through the broker so users on every node see it.
+func publishTyping(payload types.TypingPayload, connectionID uuid.UUID) {
+	var message types.Message
+
+	message.ChannelID = payload.ChannelId
+	message.ConversationID = payload.ConversationId
+	message.Message.SenderID = payload.UserID
+	message.OriginConnectionID = &connectionID
+	message.Type = payload.Type
+	message.Data = payload
+
+	typingEvent, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("failed to encode typing event: %v", err)
+		return
+	}
+
+	if err := broker.Publish("typing_events", typingEvent); err != nil {
+		log.Printf("failed to publish typing event: %v", err)
+	}
+}
@@ -0,0 +1,52 @@
+package ws
+
+import (
+	"huddle-ws-server/broker"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestTypingExpiryIgnoresStaleTimers(t *testing.T) {
+	previous := broker.Default
+	broker.Default = broker.NewMemory()
+	defer func() {
+		broker.Default.Close()
+		broker.Default = previous
+	}()
+
+	tests := []struct {
+		name       string
+		generation func(first, current uint64) uint64
+		wantActive bool
+	}{
+		{name: "timer from before a refresh", generation: func(first, current uint64) uint64 { return first }, wantActive: true},
+		{name: "current timer", generation: func(first, current uint64) uint64 { return current }, wantActive: false},
+		{name: "explicit stop", generation: func(first, current uint64) uint64 { return 0 }, wantActive: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracker := &typingTracker{active: make(map[typingKey]*typingState)}
+			key := typingKey{userID: uuid.New(), topic: "channel:" + uuid.NewString()}
+			state := &typingState{}
+
+			tracker.mutex.Lock()
+			tracker.arm(key, state)
+			first := state.generation
+			tracker.arm(key, state)
+			tracker.active[key] = state
+			tracker.mutex.Unlock()
+			defer state.timer.Stop()
+
+			tracker.clear(key, test.generation(first, state.generation))
+
+			tracker.mutex.Lock()
+			_, active := tracker.active[key]
+			tracker.mutex.Unlock()
+			if active != test.wantActive {
+				t.Errorf("active = %v, want %v", active, test.wantActive)
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+	"huddle-ws-server/database"
+	"huddle-ws-server/types"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// A burst of messages in one conversation costs one count query per interval
+	unreadFlushInterval = 500 * time.Millisecond
+	// Counts stop here; clients show anything at the cap as "99+"
+	unreadCountCap = 100
+)
+
+// unreadTopic has exactly one of its IDs set.
+type unreadTopic struct {
+	channelID      uuid.UUID
+	conversationID uuid.UUID
+}
+
+// unreadNotifier pushes unread_counts to this node's sessions. Every node
+// sees the same events, so each one only counts for its own users.
+type unreadNotifier struct {
+	mutex sync.Mutex
+	// A uuid.Nil user stands for every local subscriber of the topic
+	pending map[unreadTopic]map[uuid.UUID]bool
+}
+
+var unread = &unreadNotifier{pending: make(map[unreadTopic]map[uuid.UUID]bool)}
+
+// QueueUnreadCounts refreshes the counters of local subscribers after the
+// topic gained or lost a message.
+func QueueUnreadCounts(channelID *uuid.UUID, conversationID *uuid.UUID) {
+	topic, ok := unreadTopicFor(channelID, conversationID)
+	if ok {
+		unread.queue(topic, uuid.Nil)
+	}
+}
+
+func unreadTopicFor(channelID *uuid.UUID, conversationID *uuid.UUID) (unreadTopic, bool) {
+	switch {
+	case channelID != nil:
+		return unreadTopic{channelID: *channelID}, true
+	case conversationID != nil:
+		return unreadTopic{conversationID: *conversationID}, true
+	}
+	return unreadTopic{}, false
+}
+
+func (notifier *unreadNotifier) queue(topic unreadTopic, userID uuid.UUID) {
+	notifier.mutex.Lock()
+	defer notifier.mutex.Unlock()
+
+	users, ok := notifier.pending[topic]
+	if !ok {
+		users = make(map[uuid.UUID]bool)
+		notifier.pending[topic] = users
+	}
+	users[userID] = true
+}
+
+func (notifier *unreadNotifier) run() {
+	ticker := time.NewTicker(unreadFlushInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		notifier.flush()
+	}
+}
+
+func (notifier *unreadNotifier) flush() {
+	notifier.mutex.Lock()
+	pending := notifier.pending
+	notifier.pending = make(map[unreadTopic]map[uuid.UUID]bool)
+	notifier.mutex.Unlock()
+
+	for topic, users := range pending {
+		var userIDs []uuid.UUID
+		if users[uuid.Nil] {
+			userIDs = WsManager.localSubscribers(topic)
+		} else {
+			for userID := range users {
+				userIDs = append(userIDs, userID)
+			}
+		}
+		if len(userIDs) == 0 {
+			continue
+		}
+
+		counts, err := countUnread(topic, userIDs)
+		if err != nil {
+			log.Printf("failed to count unread messages: %v", err)
+			continue
+		}
+		WsManager.sendUnreadCounts(topic, counts)
+	}
+}
+
+// localSubscribers lists the distinct users subscribed to the topic on this node.
+func (manager *Manager) localSubscribers(topic unreadTopic) []uuid.UUID {
+	route := types.Message{}
+	if topic.channelID != uuid.Nil {
+		route.ChannelID = &topic.channelID
+	} else {
+		route.ConversationID = &topic.conversationID
+	}
+
+	seen := make(map[uuid.UUID]bool)
+	userIDs := make([]uuid.UUID, 0)
+	manager.forEachSubscriber(route, func(client *Client) {
+		if !seen[client.UserId] {
+			seen[client.UserId] = true
+			userIDs = append(userIDs, client.UserId)
+		}
+	})
+	return userIDs
+}
+
+// countUnread counts, for each user, the messages by others newer than their
+// read cursor. Counting stops at unreadCountCap so a user who never opened a
+// large channel does not cost a scan of its whole history on every message.
+func countUnread(topic unreadTopic, userIDs []uuid.UUID) (map[uuid.UUID]int64, error) {
+	target := "SELECT ?::uuid AS conversation_id"
+	targetID := topic.conversationID
+	if topic.channelID != uuid.Nil {
+		target = "SELECT conversation_id FROM team_channels WHERE id = ?"
+		targetID = topic.channelID
+	}
+
+	var rows []struct {
+		UserID uuid.UUID
+		Unread int64
+	}
+	err := database.DB.Raw(fmt.Sprintf(`
+		WITH target AS (%s),
+		members AS (SELECT unnest(ARRAY[?]::uuid[]) AS user_id)
+		SELECT members.user_id, capped.unread
+		FROM members
+		CROSS JOIN target
+		LEFT JOIN read_cursors ON read_cursors.user_id = members.user_id
+			AND read_cursors.conversation_id = target.conversation_id
+			AND read_cursors.deleted_at IS NULL
+		CROSS JOIN LATERAL (
+			SELECT COUNT(*) AS unread FROM (
+				SELECT 1 FROM messages
+				WHERE messages.conversation_id = target.conversation_id
+					AND messages.deleted_at IS NULL
+					AND messages.sender_id <> members.user_id
+					AND (read_cursors.last_read_at IS NULL OR messages.created_at > read_cursors.last_read_at)
+				LIMIT ?
+			) AS unread_messages
+		) AS capped
+	`, target), targetID, userIDs, unreadCountCap).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[uuid.UUID]int64, len(rows))
+	for _, row := range rows {
+		counts[row.UserID] = row.Unread
+	}
+	return counts, nil
+}
+
+func (manager *Manager) sendUnreadCounts(topic unreadTopic, counts map[uuid.UUID]int64) {
+	for userID, count := range counts {
+		entry := types.UnreadCount{Unread: count}
+		if topic.channelID != uuid.Nil {
+			entry.ChannelId = &topic.channelID
+		} else {
+			entry.ConversationId = &topic.conversationID
+		}
+
+		frame, err := json.Marshal(types.Message{
+			Type: "unread_counts",
+			Data: types.UnreadCountsPayload{Counts: []types.UnreadCount{entry}},
+		})
+		if err != nil {
+			log.Printf("failed to encode unread counts: %v", err)
+			return
+		}
+		manager.sendToUser(userID, frame)
+	}
+}
@@ -2,175 +2,264 @@ package ws
 
 import (
 	"encoding/json"
-	"huddle-ws-server/rd"
+	"huddle-ws-server/broker"
 	"huddle-ws-server/types"
-	"sync"
+	"log"
+	"sync/atomic"
 
-	"github.com/gofiber/websocket/v2"
 	"github.com/google/uuid"
 )
 
-type Client struct {
-	Connection     *websocket.Conn
-	UserId         uuid.UUID
-	Channels       map[uuid.UUID]bool
-	DirectMessages map[uuid.UUID]bool
-}
-
+// Manager is a façade over a fixed set of shards keyed by user ID.
 type Manager struct {
-	clients    map[*Client]bool
-	userConns  map[uuid.UUID][]*Client
-	broadcast  chan types.Message
-	register   chan *Client
-	unregister chan *Client
-	mutex      sync.RWMutex
+	shards []*shard
+	config Config
+
+	droppedFrames    atomic.Uint64
+	rejectedMessages atomic.Uint64
 }
 
-func NewManager() *Manager {
+func NewManager(config Config) *Manager {
+	if config.Shards < 1 {
+		config.Shards = 1
+	}
+
+	shards := make([]*shard, config.Shards)
+	for i := range shards {
+		shards[i] = newShard()
+	}
+
 	return &Manager{
-		clients:    make(map[*Client]bool),
-		userConns:  make(map[uuid.UUID][]*Client),
-		broadcast:  make(chan types.Message),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
+		shards: shards,
+		config: config,
 	}
 }
 
-var WsManager = NewManager()
+var WsManager = NewManager(DefaultConfig())
 
-func (manager *Manager) Start() {
-	for {
-		select {
-		case client := <-manager.register:
-			manager.mutex.Lock()
-			manager.clients[client] = true
-			manager.userConns[client.UserId] = append(manager.userConns[client.UserId], client)
-			manager.mutex.Unlock()
-
-			statusPayload, _ := json.Marshal(map[string]interface{}{
-				"userId": client.UserId.String(),
-				"status": "online",
-			})
-
-			// Broadcast online user
-			rd.Publish("user_online_status", statusPayload)
-
-		case client := <-manager.unregister:
-			manager.mutex.Lock()
-			if _, ok := manager.clients[client]; ok {
-				delete(manager.clients, client)
-				manager.removeUserConnections(client)
-				client.Connection.Close()
-
-				if activeUserConnections, exists := manager.userConns[client.UserId]; !exists || len(activeUserConnections) == 0 {
-					statusPayload, _ := json.Marshal(map[string]interface{}{
-						"userId": client.UserId.String(),
-						"status": "offline",
-					})
-					rd.Publish("user_online_status", statusPayload)
-
-				}
+// DroppedFrames returns the number of frames dropped across all clients.
+func (manager *Manager) DroppedFrames() uint64 {
+	return manager.droppedFrames.Load()
+}
 
-			}
-			manager.mutex.Unlock()
-		case message := <-manager.broadcast:
-			manager.BroadcastMessage(message)
-		}
+// RejectedMessages returns the number of broadcasts dropped for having no valid route.
+func (manager *Manager) RejectedMessages() uint64 {
+	return manager.rejectedMessages.Load()
+}
 
-	}
+func (manager *Manager) shardFor(userID uuid.UUID) *shard {
+	return manager.shards[shardIndex(userID, len(manager.shards))]
 }
 
-func (manager *Manager) removeUserConnections(client *Client) {
-	if connections, ok := manager.userConns[client.UserId]; ok {
-		newConnections := make([]*Client, 0)
-		for _, connection := range connections {
-			if connection != client {
-				newConnections = append(newConnections, connection)
-			}
-		}
-		if len(newConnections) == 0 {
-			delete(manager.userConns, client.UserId)
-		} else {
-			manager.userConns[client.UserId] = newConnections
-		}
+func (manager *Manager) register(client *Client) {
+	manager.shardFor(client.UserId).register <- client
+}
+
+func (manager *Manager) unregister(client *Client) {
+	manager.shardFor(client.UserId).unregister <- client
+}
+
+func (manager *Manager) Start() {
+	for _, shard := range manager.shards {
+		go shard.run()
 	}
+	go manager.watchIdle()
+	go lastSeen.run()
+	go unread.run()
 }
 
-func (manager *Manager) BroadcastUserStatus(userID uuid.UUID, status string) {
-	manager.mutex.RLock()
-	defer manager.mutex.RUnlock()
+// forEachSubscriber visits the subscribers of every shard, holding one shard's read lock at a time.
+func (manager *Manager) forEachSubscriber(msg types.Message, fn func(client *Client)) {
+	for _, shard := range manager.shards {
+		shard.mutex.RLock()
+		shard.forEachSubscriber(msg, fn)
+		shard.mutex.RUnlock()
+	}
+}
 
+func (manager *Manager) BroadcastUserStatus(userID uuid.UUID, status types.UserStatusPayload) {
 	statusUpdate := types.Message{
 		Type: "user_status",
-		Data: map[string]interface{}{
-			"userId": userID,
-			"status": status,
-		},
-	}
-
-	// Broadcast to all connected clients
-	for client := range manager.clients {
-		err := client.Connection.WriteJSON(statusUpdate)
-		if err != nil {
-			go func(c *Client) {
-				manager.unregister <- c
-			}(client)
+		Data: status,
+	}
+
+	frame, err := json.Marshal(statusUpdate)
+	if err != nil {
+		log.Printf("failed to encode status update: %v", err)
+		return
+	}
+
+	// Only teammates and DM partners may see the user's presence
+	audience, err := presenceAudience.get(userID)
+	if err != nil {
+		log.Printf("failed to load presence audience for user %s: %v", userID, err)
+		return
+	}
+
+	for _, audienceUserID := range audience {
+		manager.sendToUser(audienceUserID, frame)
+	}
+}
+
+// SendToUser delivers msg to every session of the user across the cluster.
+func (manager *Manager) SendToUser(userID uuid.UUID, msg types.Message) error {
+	payload, err := json.Marshal(types.UserEvent{UserId: userID, Event: msg})
+	if err != nil {
+		return err
+	}
+	return broker.Publish("user_events", payload)
+}
+
+// DeliverUserEvent hands a user_events message to the user's sessions on this node.
+func (manager *Manager) DeliverUserEvent(event types.UserEvent) {
+	frame, err := json.Marshal(event.Event)
+	if err != nil {
+		log.Printf("failed to encode user event: %v", err)
+		return
+	}
+	manager.sendToUser(event.UserId, frame)
+}
+
+// sendToUser queues frame on every local connection of the user.
+func (manager *Manager) sendToUser(userID uuid.UUID, frame []byte) {
+	shard := manager.shardFor(userID)
+	shard.mutex.RLock()
+	defer shard.mutex.RUnlock()
+
+	for _, client := range shard.userConns[userID] {
+		client.enqueue(frame)
+	}
+}
+
+func (manager *Manager) sendToAll(frame []byte) {
+	for _, shard := range manager.shards {
+		shard.mutex.RLock()
+		for client := range shard.clients {
+			client.enqueue(frame)
 		}
+		shard.mutex.RUnlock()
+	}
+}
+
+// RequestResync tells every connected client it may have missed events and should refetch.
+func (manager *Manager) RequestResync() {
+	frame, err := json.Marshal(types.Message{Type: "resync_required"})
+	if err != nil {
+		log.Printf("failed to encode resync request: %v", err)
+		return
 	}
+	manager.sendToAll(frame)
 }
 
 func (manager *Manager) SubscribeToChannel(client *Client, channelId uuid.UUID) {
-	manager.mutex.Lock()
-	defer manager.mutex.Unlock()
+	shard := manager.shardFor(client.UserId)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
 	client.Channels[channelId] = true
+	if shard.clients[client] {
+		addToIndex(shard.channelSubs, channelId, client)
+	}
 }
 
 func (manager *Manager) SubscribeToConversation(client *Client, conversationID uuid.UUID) {
-	manager.mutex.Lock()
-	defer manager.mutex.Unlock()
+	shard := manager.shardFor(client.UserId)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
 	client.DirectMessages[conversationID] = true
+	if shard.clients[client] {
+		addToIndex(shard.conversationSubs, conversationID, client)
+	}
+}
+
+func (manager *Manager) UnsubscribeFromChannel(client *Client, channelId uuid.UUID) {
+	shard := manager.shardFor(client.UserId)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	delete(client.Channels, channelId)
+	removeFromIndex(shard.channelSubs, channelId, client)
 }
 
-func (manager *Manager) BroadcastReaction(channelID *uuid.UUID, messageID string, reaction types.MessageReactionResponse, action string) {
-	manager.broadcast <- types.Message{
-		Type:      "reaction",
-		ChannelID: channelID,
-		Reaction: &types.MessageReactionEvent{
-			MessageID: messageID,
-			Reaction:  reaction,
-			Action:    action,
-		},
+func (manager *Manager) UnsubscribeFromConversation(client *Client, conversationID uuid.UUID) {
+	shard := manager.shardFor(client.UserId)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	delete(client.DirectMessages, conversationID)
+	removeFromIndex(shard.conversationSubs, conversationID, client)
+}
+
+// SubscribeToTeam indexes the client as a member of the team for team-routed events.
+func (manager *Manager) SubscribeToTeam(client *Client, teamID uuid.UUID) {
+	shard := manager.shardFor(client.UserId)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	client.Teams[teamID] = true
+	if shard.clients[client] {
+		addToIndex(shard.teamSubs, teamID, client)
+	}
+}
+
+func (manager *Manager) UnsubscribeFromTeam(client *Client, teamID uuid.UUID) {
+	shard := manager.shardFor(client.UserId)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	delete(client.Teams, teamID)
+	removeFromIndex(shard.teamSubs, teamID, client)
+}
+
+// BroadcastReaction delivers a reaction event to the channel or conversation of its message.
+func (manager *Manager) BroadcastReaction(msg types.Message) {
+	if msg.Reaction == nil || topicFor(msg.ChannelID, msg.ConversationID) == "" {
+		log.Printf("dropping reaction event without a message or topic")
+		return
 	}
+	manager.BroadcastMessage(msg)
 }
 
 func (m *Manager) BroadcastMessage(msg types.Message) {
-	m.mutex.RLock()
-	defer m.mutex.RUnlock()
+	if err := m.resolveRoute(&msg); err != nil {
+		m.rejectedMessages.Add(1)
+		log.Printf("rejected unroutable %s event: %v", msg.Type, err)
+		return
+	}
 
-	for client := range m.clients {
-		if client.UserId.String() == msg.Message.SenderID {
-			continue
+	// Recipient lists and signatures stay on the server
+	routed := msg
+	msg.UserIds = nil
+	msg.Admin = nil
+
+	// Recipients other than the sender always see isMe false
+	msg.Message.IsMe = false
+	frame, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("failed to encode broadcast: %v", err)
+		return
+	}
+
+	// The sender's other sessions get their own copy with isMe set
+	var senderFrame []byte
+	echo := !isEphemeral(msg)
+	if echo {
+		msg.Message.IsMe = true
+		if senderFrame, err = json.Marshal(msg); err != nil {
+			log.Printf("failed to encode broadcast: %v", err)
+			return
 		}
+	}
 
-		// For channel messages
-		if msg.ChannelID != nil {
-			if subscribed := client.Channels[*msg.ChannelID]; !subscribed {
-				continue
-			}
+	topic := sequenceTopic(msg)
+
+	m.forEachRecipient(routed, func(client *Client) {
+		if msg.OriginConnectionID != nil && client.ConnectionId == *msg.OriginConnectionID {
+			return
 		}
 
-		// For direct messages
-		if msg.ConversationID != nil {
-			if subscribed := client.DirectMessages[*msg.ConversationID]; !subscribed {
-				continue
+		if msg.Message.SenderID != "" && client.UserId.String() == msg.Message.SenderID {
+			if echo {
+				client.enqueueOutbound(outbound{frame: senderFrame, topic: topic, seq: msg.Seq})
 			}
+			return
 		}
 
-		err := client.Connection.WriteJSON(msg)
-		if err != nil {
-			go func(c *Client) {
-				m.unregister <- c
-			}(client)
-		}
-	}
+		client.enqueueOutbound(outbound{frame: frame, topic: topic, seq: msg.Seq})
+	})
 }